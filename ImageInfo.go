@@ -4,6 +4,8 @@ import (
 	"errors"
 	"log"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,6 +19,8 @@ type ImageInfo struct {
 	Node        string            `json:name`        // 指定节点Node
 	ContainerID string            `json:containerID` // 容器ID
 	CreateTime  time.Time         `json:createTime`  // 启动时间
+	Spec        ContainerSpec     `json:"spec"`      // 容器参数
+	Secrets     map[string]string `json:"secrets"`   // 环境变量名 -> 密钥名称
 }
 
 // NodeImage 节点上运行的镜像信息， 以供正常使用
@@ -26,6 +30,8 @@ type NodeImage struct {
 	Env          map[string]string `json:env`         // 环境变量
 	Node         string            `json:name`        // 指定节点Node
 	ContainerID  string            `json:containerID` // 容器ID
+	Spec         ContainerSpec     `json:"spec"`      // 容器参数，keeper 重启容器时使用
+	Secrets      map[string]string `json:"secrets"`   // 环境变量名 -> 密钥名称，keeper 重启容器时重新解密
 }
 
 // NodeImages keeper启动成功/失败的镜像（复数）信息
@@ -36,6 +42,35 @@ func NewNodeImages() NodeImages {
 	return make(map[string]NodeImage)
 }
 
+// replicaSeparator 副本 key 中服务名与序号的分隔符
+const replicaSeparator = "#"
+
+// ReplicaKey 服务副本在 NodeImages 中的 key：序号为0时为服务名，否则为 服务名#序号
+// 使用动态端口的服务在同一节点上可以运行多个副本，以序号区分
+func ReplicaKey(serviceName string, slot int) string {
+	if slot == 0 {
+		return serviceName
+	}
+	return serviceName + replicaSeparator + strconv.Itoa(slot)
+}
+
+// ReplicaService 副本 key 对应的服务名
+func ReplicaService(key string) string {
+	return strings.SplitN(key, replicaSeparator, 2)[0]
+}
+
+// Replicas 节点上此服务所有副本的 key，按 key 排序
+func (n NodeImages) Replicas(serviceName string) []string {
+	var keys []string
+	for key := range n {
+		if ReplicaService(key) == serviceName {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func SplitFullName(fullName string) (string, string, error) {
 	str := strings.Split(fullName, "/")
 	if len(str) < 2 {
@@ -65,6 +100,8 @@ func (i *ImageInfo) Converter(d DockerImage) (err error) {
 	i.Node = d.Node
 	i.FullName = d.FullName
 	i.Env = d.Env
+	i.Spec = d.Spec
+	i.Secrets = d.Secrets
 	i.Name, i.Version, err = SplitFullName(i.FullName)
 	if err != nil {
 		return err
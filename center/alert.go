@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultRepeatInterval 问题持续存在时重复通知的默认间隔
+const defaultRepeatInterval = time.Hour
+
+// alertKey 周期性检查发现的一个问题：事件类型，服务，节点，以及副本的端口（同一节点上的多个副本分别记录）
+type alertKey struct {
+	Type        string
+	ServiceName string
+	Node        string
+	Port        string
+}
+
+// alertState 问题开始的时间，最近一次通知的时间，以及问题的级别
+type alertState struct {
+	Since        time.Time
+	LastNotified time.Time
+	Severity     string
+}
+
+// alertTracker 记录周期性检查发现的问题，问题开始时通知一次，持续存在时每隔 repeatInterval 重复通知，问题消失时通知已恢复
+// 只在 Run 的检查中调用；状态只保存在内存中，center 重启后持续存在的问题会再通知一次
+type alertTracker struct {
+	active map[alertKey]*alertState
+}
+
+func newAlertTracker() *alertTracker {
+	return &alertTracker{active: make(map[alertKey]*alertState)}
+}
+
+// update 根据本次检查发现的问题，返回需要通知的事件
+// checked 为本次检查确实检查过的事件类型，只有这些类型的问题没有再次出现时才认为已恢复
+func (t *alertTracker) update(problems []Event, repeatInterval time.Duration, checked ...string) []Event {
+	now := time.Now()
+	var events []Event
+	found := make(map[alertKey]bool)
+	for _, e := range problems {
+		key := alertKey{e.Type, e.ServiceName, e.Node, e.Port}
+		found[key] = true
+		state, ok := t.active[key]
+		if !ok {
+			t.active[key] = &alertState{Since: now, LastNotified: now, Severity: e.Severity}
+			events = append(events, e)
+			continue
+		}
+		if now.Sub(state.LastNotified) >= repeatInterval {
+			state.LastNotified = now
+			e.Message = fmt.Sprintf("%s (since %s)", e.Message, state.Since.Format("2006-01-02 15:04:05"))
+			events = append(events, e)
+		}
+	}
+	for key, state := range t.active {
+		if found[key] || !contains(checked, key.Type) {
+			continue
+		}
+		delete(t.active, key)
+		// 恢复通知使用问题的级别，与问题通知发送给相同的通知方式
+		events = append(events, Event{
+			Type:        EventAlertResolved,
+			Severity:    state.Severity,
+			ServiceName: key.ServiceName,
+			Node:        key.Node,
+			Port:        key.Port,
+			Message: fmt.Sprintf("Alert-Resolved: %s, service: %s, node: %s, port: %s, lasted from %s",
+				key.Type, key.ServiceName, key.Node, key.Port, state.Since.Format("2006-01-02 15:04:05")),
+			Time: now,
+		})
+	}
+	return events
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertTracker(t *testing.T) {
+	tracker := newAlertTracker()
+	problem := func(port string) Event {
+		return Event{Type: EventRegisterFailed, Severity: SeverityError, ServiceName: "hello", Node: "node1", Port: port, Message: "Register: fail"}
+	}
+
+	// 同一节点上两个副本没有注册，分别通知
+	events := tracker.update([]Event{problem("8001"), problem("8002")}, time.Hour, EventRegisterFailed)
+	assert.Len(t, events, 2)
+
+	// 问题持续存在，没有到重复通知的间隔时不通知
+	events = tracker.update([]Event{problem("8001"), problem("8002")}, time.Hour, EventRegisterFailed)
+	assert.Empty(t, events)
+
+	// 到了重复通知的间隔，消息中带上问题开始的时间
+	events = tracker.update([]Event{problem("8001")}, 0, EventCheckFailed)
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0].Message, "(since ")
+	}
+	// 没有检查注册时，不认为 8002 已恢复
+	assert.Len(t, tracker.active, 2)
+
+	// 8002 注册恢复，使用问题的级别通知
+	events = tracker.update([]Event{problem("8001")}, time.Hour, EventRegisterFailed)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventAlertResolved, events[0].Type)
+		assert.Equal(t, SeverityError, events[0].Severity)
+		assert.Equal(t, "8002", events[0].Port)
+	}
+}
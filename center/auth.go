@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/hmac"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// BriskSecret center 的共享密钥，修改配置的请求需要携带，镜像事件用它签名，未配置时拒绝所有修改和镜像事件
+var BriskSecret = os.Getenv("BriskSecret")
+
+// bearerAuth 校验请求头 Authorization: Bearer "BriskSecret"，logPrefix 为日志前缀，例如 Config
+func bearerAuth(logPrefix string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if BriskSecret == "" || !hmac.Equal([]byte(token), []byte(BriskSecret)) {
+				log.Printf("%s-Error: token mismatch, remote: %s \n", logPrefix, c.RealIP())
+				return echo.NewHTTPError(http.StatusUnauthorized, "token mismatch")
+			}
+			return next(c)
+		}
+	}
+}
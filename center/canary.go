@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"brisk"
+)
+
+const (
+	// canaryProbeInterval 金丝雀副本观察期间的检查间隔
+	canaryProbeInterval = 10 * time.Second
+	// defaultCanarySoak 未配置 SoakTime 时的观察时间
+	defaultCanarySoak = time.Minute
+)
+
+// soakCanary 等待 strategy.InitialDelay 秒之后，观察金丝雀副本 strategy.SoakTime 秒：
+// 副本需要一直注册在 service-"ServiceName"-* 下，并且 HTTP 健康检查通过，连续 strategy.FailureThreshold 次检查失败即返回错误
+// 观察期间可以被暂停（暂停的时间不计入观察时间）或取消，取消时返回 errRolloutCancelled
+func (s *Scheduler) soakCanary(canary brisk.DockerImage, strategy brisk.Strategy, control *rolloutControl) error {
+	serviceName := canary.Env["ServiceName"]
+	soak := time.Duration(strategy.SoakTime) * time.Second
+	if soak <= 0 {
+		soak = defaultCanarySoak
+	}
+	threshold := strategy.FailureThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	initialDelay := time.Duration(strategy.InitialDelay) * time.Second
+	msg := fmt.Sprintf("Rolling-Canary: service: %s, canary replica on node: %s, initial delay %v, soak %v \n", serviceName, canary.Node, initialDelay, soak)
+	log.Print(msg)
+	s.emit(Event{Type: EventCanaryStarted, Severity: SeverityInfo, ServiceName: serviceName, Node: canary.Node, Message: msg})
+	deadline := time.Now().Add(initialDelay + soak)
+	probeAfter := time.Now().Add(initialDelay)
+	ticker := time.NewTicker(canaryProbeInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-control.watch():
+			pausedAt := time.Now()
+			if err := control.wait(s); err != nil {
+				return err
+			}
+			paused := time.Since(pausedAt)
+			deadline, probeAfter = deadline.Add(paused), probeAfter.Add(paused)
+			continue
+		case <-ticker.C:
+		}
+		if time.Now().Before(probeAfter) {
+			continue
+		}
+		if err := probeCanary(canary, strategy.HealthPath); err != nil {
+			failures++
+			if failures < threshold {
+				log.Printf("Rolling-Canary-Info: service: %s, node: %s, probe failed %d/%d, err: %v \n", serviceName, canary.Node, failures, threshold, err)
+				continue
+			}
+			msg := fmt.Sprintf("Rolling-Canary-Fail: service: %s, node: %s, probe failed %d times, err: %v \n", serviceName, canary.Node, failures, err)
+			log.Print(msg)
+			s.emit(Event{Type: EventCanaryFailed, Severity: SeverityError, ServiceName: serviceName, Node: canary.Node, Message: msg})
+			return errors.New(msg)
+		}
+		failures = 0
+		if !time.Now().Before(deadline) {
+			break
+		}
+	}
+	msg = fmt.Sprintf("Rolling-Canary-Successful: service: %s, canary replica on node %s is healthy \n", serviceName, canary.Node)
+	log.Print(msg)
+	s.emit(Event{Type: EventCanaryPassed, Severity: SeverityInfo, ServiceName: serviceName, Node: canary.Node, Message: msg})
+	return nil
+}
+
+// probeCanary 检查金丝雀副本的容器是否注册，并请求健康检查地址
+// 只接受keeper记录的金丝雀容器的注册，旧容器残留的注册不算通过
+func probeCanary(canary brisk.DockerImage, healthPath string) error {
+	serviceName := canary.Env["ServiceName"]
+	containerID, err := canaryContainer(canary)
+	if err != nil {
+		return err
+	}
+	serverInfoMap, err := getAllRegisterServ()
+	if err != nil {
+		return err
+	}
+	registered, ok := findCanaryRegistration(serverInfoMap[serviceName], canary.Node, containerID)
+	if !ok {
+		msg := fmt.Sprintf("service %s is not registered on node %s by container %s", serviceName, canary.Node, containerID)
+		return errors.New(msg)
+	}
+	if healthPath == "" {
+		return nil
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s:%s%s", registered.IP, registered.Port, healthPath))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := fmt.Sprintf("health check %s returns %d", healthPath, resp.StatusCode)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// canaryContainer keeper-"HostName"-image 中金丝雀副本（canary.Replica）记录的运行金丝雀镜像的容器ID
+func canaryContainer(canary brisk.DockerImage) (string, error) {
+	images, err := getNodeImages(canary.Node)
+	if err != nil {
+		return "", err
+	}
+	replica := canary.Replica
+	if replica == "" {
+		replica = canary.Env["ServiceName"]
+	}
+	nodeImage, ok := images[replica]
+	if !ok || nodeImage.FullName != canary.FullName || nodeImage.ContainerID == "" {
+		msg := fmt.Sprintf("keeper on node %s is not running image %s", canary.Node, canary.FullName)
+		return "", errors.New(msg)
+	}
+	return nodeImage.ContainerID, nil
+}
+
+// findCanaryRegistration 节点上容器ID为 containerID（注册信息中为前12位）的注册
+func findCanaryRegistration(servers []brisk.ServerInfo, node string, containerID string) (brisk.ServerInfo, bool) {
+	for _, server := range servers {
+		if server.Host != node || server.ContainerID == "" {
+			continue
+		}
+		if strings.HasPrefix(containerID, server.ContainerID) {
+			return server, true
+		}
+	}
+	return brisk.ServerInfo{}, false
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindCanaryRegistration(t *testing.T) {
+	servers := []brisk.ServerInfo{
+		{ID: "old", Host: "node1", ContainerID: "0123456789ab"},
+		{ID: "legacy", Host: "node1"},
+		{ID: "new", Host: "node1", ContainerID: "ba9876543210"},
+		{ID: "other", Host: "node2", ContainerID: "ba9876543210"},
+	}
+
+	server, ok := findCanaryRegistration(servers, "node1", "ba9876543210fedcba")
+	assert.True(t, ok)
+	assert.Equal(t, "new", server.ID)
+
+	// 旧容器残留的注册以及没有容器ID的注册都不算
+	_, ok = findCanaryRegistration(servers, "node1", "ffffffffffff0000")
+	assert.False(t, ok)
+	_, ok = findCanaryRegistration(servers, "node3", "ba9876543210fedcba")
+	assert.False(t, ok)
+}
@@ -7,13 +7,14 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"brisk"
 
 	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/coreos/etcd/clientv3/concurrency"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/rs/xid"
@@ -26,28 +27,56 @@ var (
 		Endpoints:   []string{Etcd},
 		DialTimeout: 5 * time.Second,
 	})
-	scheduler = NewScheduler()
+	// scheduler 在 main 中创建，NewScheduler 读取配置文件
+	scheduler *Scheduler
 )
 
 type ServiceImageEvent struct {
 	ServiceName string    `json:"service_name"`
 	CommitHash  string    `json:"commit_hash"`
 	CreateTime  time.Time `json:"create_time"`
+	// Source 镜像事件的来源：api, registry, github, gitea, gitlab
+	Source string `json:"source"`
 }
 
 type Scheduler struct {
+	// configMu 保护 ServiceMetas, NodeMetas，配置重新加载时整体替换，读取使用 serviceConfigs(), nodeConfigs()
+	configMu sync.RWMutex
 	// 所有服务的配置信息
 	ServiceMetas brisk.AllServConfigs
 	// 所有服务器节点的配置信息
-	NodeMetas        brisk.NodeConfigs
-	HTTPServer       *echo.Echo
-	ImageEventChan   chan (ServiceImageEvent)
+	NodeMetas      brisk.NodeConfigs
+	HTTPServer     *echo.Echo
+	ImageEventChan chan (ServiceImageEvent)
+	// mu 保护 RollingServices, RollingServQueue, controls, isLeader, election, reconciling, lastRepair, orphanSince, draining
+	mu sync.Mutex
+	// 多个center实例时，只有leader处理镜像事件
+	isLeader         bool
+	election         *concurrency.Election
 	RollingServices  map[string]bool
 	RollingServQueue map[string][]ServiceImageEvent
+	// controls 正在运行的升级的控制，key 为服务名
+	controls map[string]*rolloutControl
+	// reconciling 是否正在修复服务副本
+	reconciling bool
+	// lastRepair 每个服务最近一次修复的时间
+	lastRepair map[string]time.Time
+	// orphanSince 不在服务列表中的服务第一次被发现的时间
+	orphanSince map[string]time.Time
+	// draining 正在驱逐的节点
+	draining map[string]bool
 	// 添加 收件人
 	MailAddressees brisk.MailAddressees
-	// 整段信息 保存在 s中 按照服务名 分类保存
-	MailMessage brisk.MailMessage
+	// notifyMu 保护 pendingEvents
+	notifyMu sync.Mutex
+	// pendingEvents 还没有发送的通知事件，按照服务名分类保存
+	pendingEvents map[string][]Event
+	// notifier 按服务以及级别路由通知
+	notifier *notifyRouter
+	// registerAlerts 服务注册检查发现的问题
+	registerAlerts *alertTracker
+	// events 事件流的订阅者
+	events *eventHub
 }
 
 // NewScheduler new scheduler
@@ -57,20 +86,25 @@ func NewScheduler() *Scheduler {
 		nodeMetas      brisk.NodeConfigs
 		mailAddressees brisk.MailAddressees
 	)
-	//  /Users/liamy/go/src/eglass.com/brisk/center 本地路径
-	//  /etc/center-yaml node2 服务器了路径
-	brisk.ReadYamlFile("/etc/center-yaml/ServConfigs.yaml", &serviceMetas)
-	brisk.ReadYamlFile("/etc/center-yaml/NodeConfigs.yaml", &nodeMetas)
-	brisk.ReadYamlFile("/etc/center-yaml/MailAddressee.yaml", &mailAddressees)
+	brisk.ReadYamlFile(servConfigsFile, &serviceMetas)
+	brisk.ReadYamlFile(nodeConfigsFile, &nodeMetas)
+	brisk.ReadYamlFile(mailAddresseeFile, &mailAddressees)
 
 	return &Scheduler{
 		ServiceMetas:     serviceMetas,
 		NodeMetas:        nodeMetas,
 		MailAddressees:   mailAddressees,
-		MailMessage:      make(map[string]string),
+		pendingEvents:    make(map[string][]Event),
+		notifier:         loadNotifyRouter(mailAddressees),
+		registerAlerts:   newAlertTracker(),
+		events:           newEventHub(),
 		ImageEventChan:   make(chan (ServiceImageEvent), 100),
 		RollingServices:  make(map[string]bool),
 		RollingServQueue: make(map[string][]ServiceImageEvent),
+		controls:         make(map[string]*rolloutControl),
+		lastRepair:       make(map[string]time.Time),
+		orphanSince:      make(map[string]time.Time),
+		draining:         make(map[string]bool),
 		HTTPServer: func() *echo.Echo {
 			e := echo.New()
 			e.Use(middleware.Logger())
@@ -82,13 +116,23 @@ func NewScheduler() *Scheduler {
 
 // Init 初始化
 func (s *Scheduler) Init() {
-	s.HTTPServer.POST("/api/brisk", func(c echo.Context) error {
-		var event ServiceImageEvent
-		c.Bind(&event)
-		s.ImageEventChan <- event
-		c.String(200, "accepted")
-		return nil
-	})
+	if BriskSecret == "" {
+		log.Println("Config-Error: BriskSecret is not configured, config changes and image events will be rejected")
+	}
+	if secretErr != nil {
+		log.Printf("Secret-Error: %v, secrets can not be written \n", secretErr)
+	}
+	s.HTTPServer.POST("/api/brisk", s.postImageEvent, s.leaderOnly)
+	s.initWebhookAPI()
+	s.initQueueAPI()
+	s.initControlAPI()
+	s.initHistoryAPI()
+	s.initPlanAPI()
+	s.initNodeAPI()
+	s.HTTPServer.GET("/api/events", s.streamEvents)
+	s.initMetrics()
+	s.initConfigAPI()
+	s.initSecretAPI()
 }
 
 // Run 启动
@@ -97,10 +141,19 @@ func (s *Scheduler) Run() {
 	go func() {
 		s.HTTPServer.Logger.Fatal(s.HTTPServer.Start(":20000"))
 	}()
+	// 使用etcd中保存的配置，配置文件或etcd中的配置变化时重新加载
+	go s.watchConfigStore(s.initConfigStore())
+	go s.watchConfigFiles()
+	// 选举成为leader之后 才处理镜像事件以及周期性检查
+	s.campaign()
 	// 周期性 检查服务器节点 启动，运行服务的情况
 	checkTicker := time.NewTicker(2 * time.Minute)
 	// 周期性 检查服务注册情况
 	checkRegisterTicker := time.NewTicker(3 * time.Minute)
+	// 恢复重启前未完成的滚动升级
+	s.resumeRollouts()
+	// 继续重启前未完成的节点驱逐
+	s.resumeDrains()
 	for {
 		select {
 		case e := <-s.ImageEventChan:
@@ -113,135 +166,112 @@ func (s *Scheduler) Run() {
 	}
 }
 
-// 放入以服务名分类的缓存队列
+// 放入以服务名分类的缓存队列，调用方持有 s.mu
 func (s *Scheduler) imageEventQueue(e ServiceImageEvent) {
 	log.Printf("Info: add serviceImageEvent to the queue , serviceImageEvent: %v \n", e)
 	serviceName := e.ServiceName
-	if _, ok := s.RollingServQueue[serviceName]; ok {
+	servConfig, _ := s.serviceConfig(serviceName)
+	if servConfig.Strategy.Coalesce {
+		queue := coalesceQueue(s.RollingServQueue[serviceName], e)
+		// 被取代的版本不会升级，删除记录，之后可以再次推送
+		for _, dropped := range append(s.RollingServQueue[serviceName], e) {
+			if dropped != queue[0] {
+				forgetImageEvent(serviceName, dropped.CommitHash)
+			}
+		}
+		s.RollingServQueue[serviceName] = queue
+	} else if _, ok := s.RollingServQueue[serviceName]; ok {
 		s.RollingServQueue[serviceName] = append(s.RollingServQueue[serviceName], e)
 	} else {
 		s.RollingServQueue[serviceName] = []ServiceImageEvent{e}
 	}
+	saveRollingQueue(serviceName, s.RollingServQueue[serviceName])
+	s.publishQueue(serviceName)
 	log.Printf("Info: service name: %s , queue: %v \n", serviceName, s.RollingServQueue[serviceName])
 }
 
+// coalesceQueue 队列中只保留 CreateTime 最新的镜像事件，被取代的事件丢弃
+func coalesceQueue(queue []ServiceImageEvent, e ServiceImageEvent) []ServiceImageEvent {
+	latest := e
+	for _, queued := range queue {
+		if queued.CreateTime.After(latest.CreateTime) {
+			latest = queued
+		}
+	}
+	for _, dropped := range append(queue, e) {
+		if dropped != latest {
+			log.Printf("Info: serviceImageEvent superseded by commitHash %s, dropped: %v \n", latest.CommitHash, dropped)
+		}
+	}
+	return []ServiceImageEvent{latest}
+}
+
 // 构建新的镜像信息，来 rolling-update
 func (s *Scheduler) handleNewServiceImage(e ServiceImageEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	// 保证每个服务下只有一个正在升级
 	if s.RollingServices[e.ServiceName] {
 		s.imageEventQueue(e)
 		return
 	}
-	go func() {
-		log.Printf("Info : serviceName : %s, rolling-update now : %v \n", e.ServiceName, s.RollingServices[e.ServiceName])
-		//设置此服务在滚动升级
-		serviceName := e.ServiceName
-		commitHash := e.CommitHash
-		createTime := e.CreateTime
-		log.Printf("Info: rolling-update start; service info : serviceName: [%s], commitHash: %s, createTime: %v \n", serviceName, commitHash, createTime)
-		s.RollingServices[serviceName] = true
-		// design -- images
-		dockerImages := s.designImage(serviceName, commitHash, createTime)
-		log.Printf("Info: rolling-update : dockerImages : %v \n", dockerImages)
-		if len(dockerImages) == 0 {
-			log.Println("Error : design Image error, dockerImages is empty")
-			s.RollingServices[serviceName] = false
-			return
-		}
-		// dChan DockerImage-chan
-		dChan := make(chan brisk.DockerImage, 1)
-		// 向通道中添加第一个镜像,index从0开始
-		index := 0
-		dChan <- dockerImages[index]
-		// watcher
-		watcher := clientv3.NewWatcher(cli)
-		// 监听keeper 对于镜像启动情况的反馈
-		w := watcher.Watch(context.Background(), "rolling-update-"+serviceName)
-		for {
-			select {
-			case watchResponse := <-w:
-				// 得到镜像 启动运行信息
-				for _, event := range watchResponse.Events {
-					if event.Type == mvccpb.PUT {
-						execResult, err := strconv.ParseBool(string(event.Kv.Value))
-						if err != nil {
-							msg := fmt.Sprintf("Rolling-Error : rolling-update-%s result, string ==> bool error, err : %v \n", serviceName, err)
-							s.writeMail(serviceName, msg)
-							log.Printf(msg) //completed
-							goto COMPLETED
-						}
-						msg := fmt.Sprintf("Rolling-Info : service : %s ,commitHash: %s, rolling-update feedback result : %v \n", serviceName, commitHash, execResult)
-						s.writeMail(serviceName, msg)
-						log.Printf(msg)
-						if execResult {
-							if len(dockerImages) == index {
-								msg := fmt.Sprintf("Rolling-Serv-Successful: service: %s, commitHash: %s, replicas run successfully, node: %s \n", serviceName, commitHash, dockerImages[index-1].Node)
-								log.Printf(msg)
-								s.writeMail(serviceName, msg)
-								msg = fmt.Sprintf("Rolling-AllServ-Successful: service: %s, all service replicas run successfully \n", serviceName)
-								s.writeMail(serviceName, msg)
-								log.Printf(msg)
-								goto COMPLETED
-							}
-							msg := fmt.Sprintf("Rolling-Serv-Successful: service: %s, commitHash: %s, replicas run successfully, node: %s \n", serviceName, commitHash, dockerImages[index-1].Node)
-							log.Printf(msg)
-							s.writeMail(serviceName, msg)
-							dChan <- dockerImages[index]
-						} else {
-							msg := fmt.Sprintf("Rolling-Serv-Fail: service: %s , commitHash: %s, replicas run failed, node: %s \n", serviceName, commitHash, dockerImages[index-1].Node)
-							log.Printf(msg)
-							s.writeMail(serviceName, msg)
-							goto COMPLETED
-						}
-					}
-				}
-			case d := <-dChan:
-				msg := fmt.Sprintf("Rolling-Info: put dockerImage to etcd, dockerImage-Info: %v \n", d)
-				log.Printf(msg)
-				s.writeMail(serviceName, msg)
-				err := putDockerImage(d)
-				if err != nil {
-					msg := fmt.Sprintf("Rolling-Error : Put dockerImage error , dockerImage-fullName: %s, err : %v \n", d.FullName, err)
-					log.Printf(msg)
-					s.writeMail(serviceName, msg)
-					goto COMPLETED
-				}
-				//index自增
-				index++
-			case <-time.After(2 * time.Minute):
-				// 超时处理
-				msg := fmt.Sprintf("Rolling-TimeOut : service: %s, rolling update timeout \n", serviceName)
-				log.Printf(msg)
-				s.writeMail(serviceName, msg)
-				goto COMPLETED
-			}
-		}
-	COMPLETED:
-		// 完成升级之后删除 升级信息
-		msg := fmt.Sprintf("Rolling-Completed: service %s : rolling-update Completed \n", serviceName)
-		log.Printf(msg)
-		s.writeMail(serviceName, msg)
-		// 发送邮件
-		// subject 邮件主题
-		subject := fmt.Sprintf("%s,service-name: %s", "Rolling-Update-Info", serviceName)
-		s.sendEMail(serviceName, subject)
-		// 清空已发送邮件的历史信息
-		s.writeMail(serviceName, "")
-		// 删除镜像启动的反馈信息
-		cli.Delete(context.Background(), "rolling-update-"+serviceName)
-		// 从缓存中取得 当前服务名下的镜像队列
-		queue, exist := s.RollingServQueue[serviceName]
-		// 说明rolling-update 结束
-		s.RollingServices[serviceName] = false
-		// 关闭 channel
-		close(dChan)
-		if exist && len(queue) != 0 {
-			servImageEvent := queue[0]
-			s.RollingServQueue[serviceName] = queue[1:]
-			log.Printf("Rolling-Completed: service %s : rolling-update next serviceImageEvent \n", serviceName)
-			s.ImageEventChan <- servImageEvent
+	//设置此服务在滚动升级
+	s.RollingServices[e.ServiceName] = true
+	state := newRolloutState(e)
+	state.save()
+	go s.runRollout(state)
+}
+
+// completeRollout 升级结束：发送通知，清理升级信息，取出队列中的下一个镜像事件
+func (s *Scheduler) completeRollout(state *RolloutState) {
+	serviceName := state.ServiceName
+	// 完成升级之后删除 升级信息
+	msg := fmt.Sprintf("Rolling-Completed: service %s : rolling-update Completed, outcome: %s \n", serviceName, state.Outcome)
+	log.Print(msg)
+	// 事件流的订阅者根据结束事件的级别判断升级是否成功
+	event := Event{Type: EventRolloutCompleted, Severity: SeverityInfo, ServiceName: serviceName, CommitHash: state.CommitHash, Message: msg}
+	if state.Outcome != OutcomeSuccess {
+		event.Severity = SeverityError
+	}
+	s.emit(event)
+	// 发送通知
+	// subject 通知主题
+	subject := fmt.Sprintf("%s,service-name: %s", "Rolling-Update-Info", serviceName)
+	s.flushEvents(serviceName, subject)
+	// 删除镜像启动的反馈信息
+	for _, images := range [][]brisk.DockerImage{state.Images, state.RollbackImages} {
+		for _, d := range images {
+			cli.Delete(context.Background(), brisk.RollingFeedbackPrefix+d.ID)
 		}
-	}()
+	}
+	saveRolloutRecord(state)
+	observeRollout(state)
+	s.settleImageEvents(serviceName, state.CommitHash, state.Outcome == OutcomeSuccess)
+	state.remove()
+	s.mu.Lock()
+	delete(s.controls, serviceName)
+	s.mu.Unlock()
+	s.releaseService(serviceName)
+}
+
+// releaseService 服务升级/修复结束，取出队列中的下一个镜像事件
+func (s *Scheduler) releaseService(serviceName string) {
+	s.mu.Lock()
+	// 从缓存中取得 当前服务名下的镜像队列
+	queue, exist := s.RollingServQueue[serviceName]
+	// 说明rolling-update 结束
+	s.RollingServices[serviceName] = false
+	if !exist || len(queue) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	servImageEvent := queue[0]
+	s.RollingServQueue[serviceName] = queue[1:]
+	saveRollingQueue(serviceName, s.RollingServQueue[serviceName])
+	s.publishQueue(serviceName)
+	s.mu.Unlock()
+	log.Printf("Rolling-Completed: service %s : rolling-update next serviceImageEvent \n", serviceName)
+	s.ImageEventChan <- servImageEvent
 }
 
 // 将整理完成的 dockerImage PUT到etcd中心，以供keeper启动镜像使用
@@ -263,63 +293,65 @@ func putDockerImage(dockerImage brisk.DockerImage) error {
 }
 
 // ServConvImage 服务配置信息 转 docker镜像信息
-func (s *Scheduler) designImage(serviceName string, commitHash string, createTime time.Time) []brisk.DockerImage {
+func (s *Scheduler) designImage(serviceName string, commitHash string, createTime time.Time) ([]brisk.DockerImage, error) {
 	log.Printf("Info: start design image, serviceName：%s, commitHash: %s, createTime: %v \n", serviceName, commitHash, createTime)
 	// 镜像列表
 	var dockerImages []brisk.DockerImage
 	// 取得服务配置
-	servConfig := s.ServiceMetas[serviceName]
-	log.Printf("Info: 获取服务器列表：%v \n", servConfig)
-	// 获取服务器列表
-	nodeConfigs := s.getServerNode(servConfig.Meta.NeedNetPublic)
-	log.Printf("Info: 获取服务器列表：%v \n", nodeConfigs)
+	servConfig, ok := s.serviceConfig(serviceName)
+	if !ok {
+		msg := fmt.Sprintf("design image: service %s is not configured", serviceName)
+		return nil, errors.New(msg)
+	}
+	log.Printf("Info: 获取服务配置：%v \n", servConfig)
+	if err := checkSecrets(servConfig); err != nil {
+		return nil, err
+	}
+	// 根据节点容量，公网需求 选择副本所在的服务器节点
+	slots, err := s.placeReplicas(servConfig)
+	if err != nil {
+		return nil, err
+	}
+	// 动态端口的服务为新的副本分配宿主机端口
+	if err := assignPorts(servConfig, slots); err != nil {
+		return nil, err
+	}
+	log.Printf("Info: 获取服务器列表：%v \n", slots)
 	// 取得镜像全名
 	fullName := fmt.Sprintf("%s:%s", servConfig.Meta.ImagePrefix, commitHash)
 	log.Printf("Info: fullName %s \n", fullName)
-	for i := 0; i < servConfig.Replica; i++ {
-		// 目前只能是把有公网的服务器 放在第一位  逻辑存在一些问题
-		// qualified := !servConfig.Meta.NeedNetPublic || nodeConfigs[i].HasPublic
-		// if !qualified {
-		// 	continue
-		// }
-		dockerImage := brisk.DockerImage{
-			ID:       fmt.Sprintf("%s", xid.New()),
-			FullName: fullName,
-			Env: map[string]string{
-				"IP":            nodeConfigs[i].PrivateIP,
-				"Port":          servConfig.Meta.Port,
-				"ContainerPort": servConfig.Meta.ContainerPort,
-				"Host":          nodeConfigs[i].HostName,
-				"Etcd":          servConfig.Meta.Etcd,
-				"ServiceName":   servConfig.ServiceName,
-			},
-			Node:       nodeConfigs[i].HostName,
-			CreateTime: createTime,
-		}
-		dockerImages = append(dockerImages, dockerImage)
+	for _, slot := range slots {
+		dockerImages = append(dockerImages, newDockerImage(servConfig, slot, fullName, createTime))
 	}
 	log.Printf("Info: image design finished, serviceName：%s \n", serviceName)
 	log.Printf("Info: images：%v \n", dockerImages)
-	return dockerImages
+	return dockerImages, nil
 }
 
-// 拓展，获取服务器node列表
-func (s *Scheduler) getServerNode(hasPubNet bool) map[int]brisk.NodeConfig {
-	// 目前这段逻辑 基于的是 服务器数量与副本数量 一定是对等的情况
-	nodeMetas := make(map[int]brisk.NodeConfig)
-	i := 0
-	for _, value := range s.NodeMetas {
-		if hasPubNet {
-			if value.HasPublic {
-				nodeMetas[i] = value
-				i++
-			}
-		} else {
-			nodeMetas[i] = value
-			i++
-		}
+// newDockerImage 服务副本在节点上运行使用的镜像信息，slot.Port 不为空时（动态分配的端口）替换 Meta.Port
+func newDockerImage(servConfig brisk.ServConfigs, slot replicaSlot, fullName string, createTime time.Time) brisk.DockerImage {
+	node := slot.Node
+	port := servConfig.Meta.Port
+	if slot.Port != "" {
+		port = slot.Port
+	}
+	return brisk.DockerImage{
+		ID:       fmt.Sprintf("%s", xid.New()),
+		FullName: fullName,
+		Env: map[string]string{
+			"IP":            node.PrivateIP,
+			"Port":          port,
+			"ContainerPort": servConfig.Meta.ContainerPort,
+			"Host":          node.HostName,
+			"Etcd":          servConfig.Meta.Etcd,
+			"ServiceName":   servConfig.ServiceName,
+		},
+		Node:       node.HostName,
+		CreateTime: createTime,
+		Spec:       servConfig.Meta.Container,
+		Replica:    slot.Key,
+		Secrets:    servConfig.Secrets,
 	}
-	return nodeMetas
 }
 
 // CheckAllServiceDeployed 检查所有的服务副本 启动情况
@@ -331,10 +363,16 @@ func (s *Scheduler) CheckAllServiceDeployed() {
 	} else {
 		log.Printf("Check-Info: keeper running now, keeper : %v \n", keeperHost)
 	}
+	liveKeepers.Set(float64(len(keeperHost)))
 	// 获取每个节点上成功运行的服务
+	nodeImages := getAllNodeImages(keeperHost, "Check")
 	successfulImages := getAllSuccService(keeperHost, "Check")
 	// 目前启动的服务与服务列表中的服务进行对比,检查
-	analysisServReplica(successfulImages)
+	failServName := analysisServReplica(successfulImages)
+	// 修复副本数量不正确的服务，以及不在服务列表中的服务
+	s.reconcile(nodeImages, failServName)
+	// 释放没有副本使用的动态端口
+	s.releasePorts(nodeImages)
 }
 
 func getAllNolKeeper() ([]string, error) {
@@ -358,27 +396,14 @@ func getAllNolKeeper() ([]string, error) {
 
 func getAllSuccService(keeperHost []string, logPrefix string) map[string][]brisk.NodeImage {
 	imageResult := make(map[string][]brisk.NodeImage)
-	for _, hostName := range keeperHost {
-		log.Printf("%s-Info: center get all the services that run successfully on the node:%s \n", logPrefix, hostName)
-		resp, err := cli.Get(context.Background(), "keeper-"+hostName+"-image")
-		if err != nil {
-			log.Printf("%s-Error: center get all service error, err : %v \n", logPrefix, err)
-			continue
-		}
-		var succImages brisk.NodeImages
-		if len(resp.Kvs) <= 0 || resp.Kvs[0] == nil || string(resp.Kvs[0].Value) == "{}" {
-			err = errors.New("Etcd resp.Kvs value is not exist")
-			log.Printf("%s-Error : get successNodeImages error , err : %v \n", logPrefix, err)
+	for hostName, succImages := range getAllNodeImages(keeperHost, logPrefix) {
+		if len(succImages) == 0 {
+			log.Printf("%s-Error : get successNodeImages error , err : Etcd resp.Kvs value is not exist, node: %s \n", logPrefix, hostName)
 			continue
 		}
-		err = json.Unmarshal(resp.Kvs[0].Value, &succImages)
-		if err != nil {
-			log.Printf("%s-Error : etcd registered format error %v \n", logPrefix, err)
-			continue
-		}
-		log.Printf("%s-Info: Node HostName: %s; all the services that run successfully on the node, service imageInfo : %v \n", logPrefix, hostName, succImages)
-		//整理 出最终结果
-		for name, nodeimage := range succImages {
+		//整理 出最终结果，同一节点上的多个副本归入同一服务
+		for key, nodeimage := range succImages {
+			name := brisk.ReplicaService(key)
 			if _, ok := imageResult[name]; ok {
 				imageResult[name] = append(imageResult[name], nodeimage)
 			} else {
@@ -390,12 +415,49 @@ func getAllSuccService(keeperHost []string, logPrefix string) map[string][]brisk
 	return imageResult
 }
 
-// analysisServReplica 服务列表中的服务 对比 目前已查到的启动的服务 --> 分析结果
-func analysisServReplica(succImages map[string][]brisk.NodeImage) {
+// getAllNodeImages 获取每个keeper节点上成功运行的镜像，key 为节点 HostName
+// 节点上没有运行镜像时，对应的值为空的 NodeImages；读取失败的节点不包含在结果中
+func getAllNodeImages(keeperHost []string, logPrefix string) map[string]brisk.NodeImages {
+	result := make(map[string]brisk.NodeImages)
+	for _, hostName := range keeperHost {
+		log.Printf("%s-Info: center get all the services that run successfully on the node:%s \n", logPrefix, hostName)
+		succImages, err := getNodeImages(hostName)
+		if err != nil {
+			log.Printf("%s-Error: center get all service error, err : %v \n", logPrefix, err)
+			continue
+		}
+		log.Printf("%s-Info: Node HostName: %s; all the services that run successfully on the node, service imageInfo : %v \n", logPrefix, hostName, succImages)
+		result[hostName] = succImages
+	}
+	return result
+}
+
+// getNodeImages 获取节点上 keeper 记录的成功运行的镜像 keeper-"HostName"-image
+func getNodeImages(hostName string) (brisk.NodeImages, error) {
+	succImages := brisk.NewNodeImages()
+	resp, err := cli.Get(context.Background(), "keeper-"+hostName+"-image")
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) <= 0 || resp.Kvs[0] == nil || string(resp.Kvs[0].Value) == "{}" {
+		return succImages, nil
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, &succImages)
+	if err != nil {
+		msg := fmt.Sprintf("etcd registered format error, node: %s, err: %v", hostName, err)
+		return nil, errors.New(msg)
+	}
+	return succImages, nil
+}
+
+// analysisServReplica 服务列表中的服务 对比 目前已查到的启动的服务 --> 分析结果，返回副本数量不正确的服务
+func analysisServReplica(succImages map[string][]brisk.NodeImage) []string {
 	allSuccessful := true
 	var succServName []string
 	var failServName []string
-	for name, servConfig := range scheduler.ServiceMetas {
+	serviceMetas := scheduler.serviceConfigs()
+	observeReplicas(serviceMetas, succImages)
+	for name, servConfig := range serviceMetas {
 		if value, ok := succImages[name]; ok {
 			if servConfig.Replica == len(value) {
 				log.Printf("Check-Info-ServiceOK: serviceName: %s ; service starts normally，the number of service replicas is correct, ,expect : %v, actual : %v \n", name, servConfig.Replica, len(value))
@@ -416,46 +478,13 @@ func analysisServReplica(succImages map[string][]brisk.NodeImage) {
 	} else {
 		log.Printf("Check-Info: Some Service-replicas Wrong: Some services replicas failed to start, service-name: --success: %v --fail: %v \n", succServName, failServName)
 	}
+	return failServName
 }
 
 func main() {
-	sch := NewScheduler()
-	sch.Init()
-	sch.Run()
-}
-
-// writeMail 编写邮件信息，传入msg为""时，标明删除缓存中服务名对应的邮件信息
-func (s *Scheduler) writeMail(serviceName, msg string) {
-	if msg == "" {
-		log.Println("SendMail: MailMessage delete old message")
-		delete(s.MailMessage, serviceName)
-	} else {
-		msg = fmt.Sprintf(time.Now().Format("2006-01-02 15:04:05") + "：" + msg)
-		s.MailMessage[serviceName] = s.MailMessage[serviceName] + msg
-	}
-}
-
-// sendEMail 发送信息，serviceName为对应的服务名称, subject 为邮件主题
-func (s *Scheduler) sendEMail(serviceName string, subject string) {
-	log.Println("SendMail: center want to send mail")
-	mailMessage := s.MailMessage[serviceName]
-	if len(s.MailAddressees) == 0 {
-		log.Printf("SendMail-Error: No MailAddressees, Do not send mail \n")
-		return
-	}
-	var addressees []string
-	for _, value := range s.MailAddressees {
-		addressees = append(addressees, value)
-	}
-
-	log.Printf("SendMail: center send mail, %s \n", subject)
-	err := brisk.SendMailTLS(addressees, subject, mailMessage)
-	if err != nil {
-		log.Printf("SendMail-Error: center send e-mail fail, error: %v \n", err)
-	} else {
-		log.Println("SendMail: center send e-mail successful")
-	}
-	log.Println("SendMail: center send e-mail finished")
+	scheduler = NewScheduler()
+	scheduler.Init()
+	scheduler.Run()
 }
 
 // CheckServiceRegister 根据keeper节点上行成功运行的服务，监测服务是否正常注册
@@ -471,46 +500,53 @@ func (s *Scheduler) CheckServiceRegister() {
 	// 根据keeper host 获取节点上成功启动运行的服务    注册使用key:fmt.Sprintf("%s-%s-%s", "service", ServiceName, xID)
 	succServices := getAllSuccService(keeperHost, "Check-ServiceRegister")
 
+	var problems []Event
 	// 获取所有成功注册的 服务信息
 	serverInfoMap, err := getAllRegisterServ()
 	if err != nil {
 		logMsg := fmt.Sprintf("Check-ServiceRegister-Error: %v \n", err)
-		s.writeMail("serviceRegister", logMsg)
-		log.Printf(logMsg)
-		goto FINISH
-	}
-
-	// 对比检查 keeper上运行服务 与 注册服务信息 如发现错误:生成日志，邮件并发送
-	for servName, nodeImages := range succServices {
-		if values, ok := serverInfoMap[servName]; ok {
-			if len(nodeImages) != len(values) {
-				valuesMap := serverInfosToMap(values)
-				for _, nodeImage := range nodeImages {
-					if value, ok := valuesMap[nodeImage.Node]; !ok {
-						logMsg := fmt.Sprintf("ServiceName : %s，Node: %s，Status: running; Register: fail \n", servName, value.Host)
-						s.writeMail("serviceRegister", logMsg)
-						log.Printf("Check-ServiceRegister-Error: %s", logMsg)
-					}
-				}
-			}
-		} else {
+		problems = append(problems, Event{Type: EventCheckFailed, Severity: SeverityError, Message: strings.TrimSpace(logMsg), Time: time.Now()})
+		log.Print(logMsg)
+	} else {
+		// 对比检查 keeper上运行服务 与 注册服务信息 如发现错误:生成日志以及通知
+		for servName, nodeImages := range succServices {
+			valuesMap := serverInfosToMap(serverInfoMap[servName])
 			for _, nodeImage := range nodeImages {
-				logMsg := fmt.Sprintf("ServiceName : %s，Node: %s，Status: running; Register: fail \n", servName, nodeImage.Node)
-				s.writeMail("serviceRegister", logMsg)
+				if _, ok := valuesMap[nodeImage.Node+":"+nodeImage.Env["Port"]]; ok {
+					continue
+				}
+				port := nodeImage.Env["Port"]
+				logMsg := fmt.Sprintf("ServiceName : %s，Node: %s，Port: %s，Status: running; Register: fail \n", servName, nodeImage.Node, port)
+				problems = append(problems, Event{Type: EventRegisterFailed, Severity: SeverityError, ServiceName: servName, Node: nodeImage.Node, Port: port, Message: strings.TrimSpace(logMsg), Time: time.Now()})
 				log.Printf("Check-ServiceRegister-Error: %s", logMsg)
 			}
 		}
 	}
 
-FINISH:
-	// subject 邮件主题
-	if message, ok := s.MailMessage["serviceRegister"]; ok {
-		log.Printf("mail message: %s \n", message)
-		s.sendEMail("serviceRegister", "Check-Service-Register")
-		s.writeMail("serviceRegister", "")
-	} else {
+	// 问题开始时通知，持续存在时每隔 repeatInterval 重复通知，恢复时通知；检查出错时不判断注册是否恢复
+	checked := []string{EventCheckFailed}
+	if err == nil {
+		checked = append(checked, EventRegisterFailed)
+	}
+	if err == nil {
+		observeRegisterMismatches(problems)
+	}
+	events := s.registerAlerts.update(problems, s.notifier.repeatInterval, checked...)
+	if len(problems) == 0 {
 		log.Println("Check-ServiceRegister-Info: finished, all services are normal")
 	}
+	// 按服务分别通知，通知路由可以按服务选择通知方式
+	byService := make(map[string][]Event)
+	var names []string
+	for _, e := range events {
+		if _, ok := byService[e.ServiceName]; !ok {
+			names = append(names, e.ServiceName)
+		}
+		byService[e.ServiceName] = append(byService[e.ServiceName], e)
+	}
+	for _, name := range names {
+		s.sendNotification(newNotification("Check-Service-Register", name, byService[name]))
+	}
 }
 
 // getAllRegisterServ获取所有成功注册的 服务信息
@@ -539,10 +575,11 @@ func getAllRegisterServ() (map[string][]brisk.ServerInfo, error) {
 	return serverInfoMap, nil
 }
 
+// serverInfosToMap 注册信息按 Host:Port 整理，同一节点上的多个副本以端口区分
 func serverInfosToMap(source []brisk.ServerInfo) map[string]brisk.ServerInfo {
 	target := make(map[string]brisk.ServerInfo)
 	for _, server := range source {
-		target[server.Host] = server
+		target[server.Host+":"+server.Port] = server
 	}
 	return target
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"brisk"
+)
+
+// 配置文件路径，本地调试时为 /Users/liamy/go/src/eglass.com/brisk/center，node2 服务器上为 /etc/center-yaml
+const (
+	servConfigsFile   = "/etc/center-yaml/ServConfigs.yaml"
+	nodeConfigsFile   = "/etc/center-yaml/NodeConfigs.yaml"
+	mailAddresseeFile = "/etc/center-yaml/MailAddressee.yaml"
+	notifyConfigFile  = "/etc/center-yaml/Notify.yaml"
+	// configReloadInterval 检查配置文件是否变化的间隔
+	configReloadInterval = 10 * time.Second
+)
+
+// serviceConfigs 当前生效的服务配置，配置重新加载时整体替换，调用方不能修改返回的map
+func (s *Scheduler) serviceConfigs() brisk.AllServConfigs {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.ServiceMetas
+}
+
+// nodeConfigs 当前生效的节点配置，配置重新加载时整体替换，调用方不能修改返回的map
+func (s *Scheduler) nodeConfigs() brisk.NodeConfigs {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.NodeMetas
+}
+
+// serviceConfig 取得服务配置
+func (s *Scheduler) serviceConfig(serviceName string) (brisk.ServConfigs, bool) {
+	servConfig, ok := s.serviceConfigs()[serviceName]
+	return servConfig, ok
+}
+
+// applyConfigs 校验并替换服务配置以及节点配置，校验失败时保留原配置
+func (s *Scheduler) applyConfigs(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) error {
+	if err := serviceMetas.Validate(); err != nil {
+		return err
+	}
+	if err := nodeMetas.Validate(); err != nil {
+		return err
+	}
+	s.configMu.Lock()
+	oldServ, oldNode := s.ServiceMetas, s.NodeMetas
+	s.ServiceMetas, s.NodeMetas = serviceMetas, nodeMetas
+	s.configMu.Unlock()
+	for _, line := range diffConfigs("service", oldServ, serviceMetas) {
+		log.Printf("Config-Diff: %s \n", line)
+	}
+	for _, line := range diffConfigs("node", oldNode, nodeMetas) {
+		log.Printf("Config-Diff: %s \n", line)
+	}
+	return nil
+}
+
+// watchConfigFiles 周期性检查配置文件，内容变化时由leader将文件中变化的服务以及节点保存为etcd中配置的新版本
+// 只导入与上一次读取的配置文件相比新增，修改，删除的条目，不覆盖通过 HTTP 接口修改的其他配置
+func (s *Scheduler) watchConfigFiles() {
+	last := configFilesHash()
+	fileServices, fileNodes, err := loadConfigFiles()
+	if err != nil {
+		log.Printf("Config-Error: load config files error, err: %v \n", err)
+	}
+	ticker := time.NewTicker(configReloadInterval)
+	for range ticker.C {
+		current := configFilesHash()
+		if current == last {
+			continue
+		}
+		last = current
+		serviceMetas, nodeMetas, err := loadConfigFiles()
+		if err != nil {
+			log.Printf("Config-Error: load config files error, keep the previous configs, err: %v \n", err)
+			continue
+		}
+		if !s.IsLeader() {
+			log.Println("Config-Info: config files changed, ignored by follower, only the leader imports config files")
+			fileServices, fileNodes = serviceMetas, nodeMetas
+			continue
+		}
+		if err := importConfigFiles(fileServices, fileNodes, serviceMetas, nodeMetas); err != nil {
+			log.Printf("Config-Error: import config files error, keep the previous configs, err: %v \n", err)
+			continue
+		}
+		fileServices, fileNodes = serviceMetas, nodeMetas
+	}
+}
+
+// loadConfigFiles 读取服务配置文件以及节点配置文件
+func loadConfigFiles() (brisk.AllServConfigs, brisk.NodeConfigs, error) {
+	var (
+		serviceMetas brisk.AllServConfigs
+		nodeMetas    brisk.NodeConfigs
+	)
+	if err := brisk.LoadYamlFile(servConfigsFile, &serviceMetas); err != nil {
+		return nil, nil, err
+	}
+	if err := brisk.LoadYamlFile(nodeConfigsFile, &nodeMetas); err != nil {
+		return nil, nil, err
+	}
+	// 文件正在写入时可能读到空的内容
+	if len(serviceMetas) == 0 || len(nodeMetas) == 0 {
+		return nil, nil, errors.New("service configs and node configs must not be empty")
+	}
+	return serviceMetas, nodeMetas, nil
+}
+
+// importConfigFiles 将配置文件从 old 到 new 的变化应用到etcd中的当前配置，保存为新版本，各个center通过 watchConfigStore 加载
+func importConfigFiles(oldServices brisk.AllServConfigs, oldNodes brisk.NodeConfigs, newServices brisk.AllServConfigs, newNodes brisk.NodeConfigs) error {
+	revision, err := commitConfig(func(services brisk.AllServConfigs, nodes brisk.NodeConfigs) (string, error) {
+		changed := mergeFileChanges(services, oldServices, newServices)
+		changed = append(changed, mergeFileChanges(nodes, oldNodes, newNodes)...)
+		return "import from config files: " + strings.Join(changed, ","), nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Config-Info: config files imported, config revision %d \n", revision.Revision)
+	return nil
+}
+
+// mergeFileChanges 将配置文件中相对 oldFile 新增，修改，删除的条目应用到 configs（key 为名称的map），返回变化的名称
+func mergeFileChanges(configs, oldFile, newFile interface{}) []string {
+	target, oldMap, newMap := reflect.ValueOf(configs), reflect.ValueOf(oldFile), reflect.ValueOf(newFile)
+	var changed []string
+	for _, key := range newMap.MapKeys() {
+		newValue, oldValue := newMap.MapIndex(key), oldMap.MapIndex(key)
+		if !oldValue.IsValid() || !reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			target.SetMapIndex(key, newValue)
+			changed = append(changed, key.String())
+		}
+	}
+	for _, key := range oldMap.MapKeys() {
+		if !newMap.MapIndex(key).IsValid() {
+			target.SetMapIndex(key, reflect.Value{})
+			changed = append(changed, key.String())
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// configFilesHash 配置文件内容的摘要，读取失败的文件不计入
+func configFilesHash() string {
+	h := sha256.New()
+	for _, file := range []string{servConfigsFile, nodeConfigsFile} {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		h.Write(content)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// diffConfigs 对比新旧配置（key 为名称的map），返回新增，删除，修改的配置说明
+func diffConfigs(kind string, oldConfigs, newConfigs interface{}) []string {
+	oldMap, newMap := reflect.ValueOf(oldConfigs), reflect.ValueOf(newConfigs)
+	var lines []string
+	for _, key := range newMap.MapKeys() {
+		newValue := newMap.MapIndex(key).Interface()
+		oldValue := oldMap.MapIndex(key)
+		if !oldValue.IsValid() {
+			lines = append(lines, fmt.Sprintf("add %s %s: %+v", kind, key, newValue))
+		} else if !reflect.DeepEqual(oldValue.Interface(), newValue) {
+			lines = append(lines, fmt.Sprintf("update %s %s: %+v => %+v", kind, key, oldValue.Interface(), newValue))
+		}
+	}
+	for _, key := range oldMap.MapKeys() {
+		if !newMap.MapIndex(key).IsValid() {
+			lines = append(lines, fmt.Sprintf("remove %s %s: %+v", kind, key, oldMap.MapIndex(key).Interface()))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"brisk"
+
+	"github.com/labstack/echo"
+)
+
+// initConfigAPI 服务配置，节点配置的增删改查，以及配置版本的查询与回滚
+// 修改配置需要 Authorization: Bearer "BriskSecret"，并且只在leader中处理（follower 转发到leader）
+func (s *Scheduler) initConfigAPI() {
+	write := []echo.MiddlewareFunc{bearerAuth("Config"), s.leaderOnly}
+	g := s.HTTPServer.Group("/api/config")
+	g.GET("", s.getCurrentConfig)
+	g.GET("/services", s.listServiceConfigs)
+	g.GET("/services/:name", s.getServiceConfig)
+	g.PUT("/services/:name", s.putServiceConfig, write...)
+	g.DELETE("/services/:name", s.deleteServiceConfig, write...)
+	g.GET("/nodes", s.listNodeConfigs)
+	g.GET("/nodes/:name", s.getNodeConfig)
+	g.PUT("/nodes/:name", s.putNodeConfig, write...)
+	g.DELETE("/nodes/:name", s.deleteNodeConfig, write...)
+	g.GET("/revisions", listConfigRevisionsHandler)
+	g.GET("/revisions/:revision", getConfigRevisionHandler)
+	g.POST("/revisions/:revision/rollback", rollbackConfigHandler, write...)
+}
+
+// getCurrentConfig 当前生效的完整配置
+func (s *Scheduler) getCurrentConfig(c echo.Context) error {
+	current, _, err := getConfigRevision()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if current == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no config in etcd")
+	}
+	return c.JSON(http.StatusOK, current)
+}
+
+func (s *Scheduler) listServiceConfigs(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.serviceConfigs())
+}
+
+func (s *Scheduler) getServiceConfig(c echo.Context) error {
+	servConfig, ok := s.serviceConfig(c.Param("name"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "service is not configured")
+	}
+	return c.JSON(http.StatusOK, servConfig)
+}
+
+// putServiceConfig 新建或修改服务配置
+func (s *Scheduler) putServiceConfig(c echo.Context) error {
+	name := c.Param("name")
+	var servConfig brisk.ServConfigs
+	if err := c.Bind(&servConfig); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if servConfig.ServiceName == "" {
+		servConfig.ServiceName = name
+	}
+	revision, err := commitConfig(func(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) (string, error) {
+		action := "create"
+		if _, ok := serviceMetas[name]; ok {
+			action = "update"
+		}
+		serviceMetas[name] = servConfig
+		return fmt.Sprintf("%s service %s", action, name), nil
+	})
+	return configResponse(c, revision, err)
+}
+
+func (s *Scheduler) deleteServiceConfig(c echo.Context) error {
+	name := c.Param("name")
+	revision, err := commitConfig(func(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) (string, error) {
+		if _, ok := serviceMetas[name]; !ok {
+			return "", errConfigNotFound
+		}
+		delete(serviceMetas, name)
+		return fmt.Sprintf("delete service %s", name), nil
+	})
+	return configResponse(c, revision, err)
+}
+
+func (s *Scheduler) listNodeConfigs(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.nodeConfigs())
+}
+
+func (s *Scheduler) getNodeConfig(c echo.Context) error {
+	node, ok := s.nodeConfigs()[c.Param("name")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "node is not configured")
+	}
+	return c.JSON(http.StatusOK, node)
+}
+
+// putNodeConfig 新建或修改节点配置
+func (s *Scheduler) putNodeConfig(c echo.Context) error {
+	name := c.Param("name")
+	var node brisk.NodeConfig
+	if err := c.Bind(&node); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if node.HostName == "" {
+		node.HostName = name
+	}
+	revision, err := commitConfig(func(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) (string, error) {
+		action := "create"
+		if _, ok := nodeMetas[name]; ok {
+			action = "update"
+		}
+		nodeMetas[name] = node
+		return fmt.Sprintf("%s node %s", action, name), nil
+	})
+	return configResponse(c, revision, err)
+}
+
+func (s *Scheduler) deleteNodeConfig(c echo.Context) error {
+	name := c.Param("name")
+	revision, err := commitConfig(func(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) (string, error) {
+		if _, ok := nodeMetas[name]; !ok {
+			return "", errConfigNotFound
+		}
+		delete(nodeMetas, name)
+		return fmt.Sprintf("delete node %s", name), nil
+	})
+	return configResponse(c, revision, err)
+}
+
+func listConfigRevisionsHandler(c echo.Context) error {
+	revisions, err := listConfigRevisions()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+func getConfigRevisionHandler(c echo.Context) error {
+	history, err := configHistoryParam(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// rollbackConfigHandler 将配置回滚到某个历史版本，回滚同样生成一个新版本
+func rollbackConfigHandler(c echo.Context) error {
+	history, err := configHistoryParam(c)
+	if err != nil {
+		return err
+	}
+	revision, err := commitConfig(func(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) (string, error) {
+		for name := range serviceMetas {
+			delete(serviceMetas, name)
+		}
+		for name, servConfig := range history.ServiceMetas {
+			serviceMetas[name] = servConfig
+		}
+		for name := range nodeMetas {
+			delete(nodeMetas, name)
+		}
+		for name, node := range history.NodeMetas {
+			nodeMetas[name] = node
+		}
+		return fmt.Sprintf("rollback to revision %d", history.Revision), nil
+	})
+	return configResponse(c, revision, err)
+}
+
+var errConfigNotFound = errors.New("config not found")
+
+// configHistoryParam 根据路径参数 revision 取得历史版本
+func configHistoryParam(c echo.Context) (*ConfigRevision, error) {
+	revision, err := strconv.ParseInt(c.Param("revision"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "revision must be a number")
+	}
+	history, err := getConfigHistory(revision)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if history == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "revision not found")
+	}
+	return history, nil
+}
+
+// configResponse 修改配置的响应：成功时返回新版本，失败时根据错误类型返回 4xx/5xx
+func configResponse(c echo.Context, revision *ConfigRevision, err error) error {
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, revision)
+	case err == errConfigNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case err == ErrConfigConflict:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if _, ok := err.(*InvalidConfigError); ok {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// 服务配置以及节点配置保存在etcd中，每次修改生成一个新的版本：
+// center-config-current 当前生效的配置 ConfigRevision；
+// center-config-revision-"Revision" 历史版本，Revision 补齐为10位数字
+const (
+	configCurrentKey     = "center-config-current"
+	configRevisionPrefix = "center-config-revision-"
+)
+
+// ErrConfigConflict 配置在读取之后被其他请求修改
+var ErrConfigConflict = errors.New("config has been modified by another request, please retry")
+
+// InvalidConfigError 修改后的配置没有通过校验
+type InvalidConfigError struct {
+	Err error
+}
+
+func (e *InvalidConfigError) Error() string {
+	return "invalid config: " + e.Err.Error()
+}
+
+// ConfigRevision 一个版本的完整配置
+type ConfigRevision struct {
+	Revision     int64                `json:"revision"`
+	ServiceMetas brisk.AllServConfigs `json:"services"`
+	NodeMetas    brisk.NodeConfigs    `json:"nodes"`
+	Comment      string               `json:"comment"` // 修改说明，例如 update service hello
+	CreateTime   time.Time            `json:"create_time"`
+}
+
+func configRevisionKey(revision int64) string {
+	return fmt.Sprintf("%s%010d", configRevisionPrefix, revision)
+}
+
+// getConfigRevision 取得当前生效的配置，以及 center-config-current 的 ModRevision（用于修改时的并发检查）
+// etcd 中没有配置时返回 nil
+func getConfigRevision() (*ConfigRevision, int64, error) {
+	resp, err := cli.Get(context.Background(), configCurrentKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	current := &ConfigRevision{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, current); err != nil {
+		return nil, 0, err
+	}
+	return current, resp.Kvs[0].ModRevision, nil
+}
+
+// commitConfig 在当前配置的基础上修改，校验通过后保存为新版本
+// modify 修改传入的配置副本，返回修改说明；配置没有变化时不生成新版本
+func commitConfig(modify func(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) (string, error)) (*ConfigRevision, error) {
+	current, modRevision, err := getConfigRevision()
+	if err != nil {
+		return nil, err
+	}
+	next := &ConfigRevision{
+		Revision:     1,
+		ServiceMetas: make(brisk.AllServConfigs),
+		NodeMetas:    make(brisk.NodeConfigs),
+		CreateTime:   time.Now(),
+	}
+	if current != nil {
+		next.Revision = current.Revision + 1
+		for name, servConfig := range current.ServiceMetas {
+			next.ServiceMetas[name] = servConfig
+		}
+		for name, node := range current.NodeMetas {
+			next.NodeMetas[name] = node
+		}
+	}
+	next.Comment, err = modify(next.ServiceMetas, next.NodeMetas)
+	if err != nil {
+		return nil, err
+	}
+	if err := next.ServiceMetas.Validate(); err != nil {
+		return nil, &InvalidConfigError{err}
+	}
+	if err := next.NodeMetas.Validate(); err != nil {
+		return nil, &InvalidConfigError{err}
+	}
+	if current != nil && reflect.DeepEqual(current.ServiceMetas, next.ServiceMetas) && reflect.DeepEqual(current.NodeMetas, next.NodeMetas) {
+		return current, nil
+	}
+	value, err := json.Marshal(next)
+	if err != nil {
+		return nil, err
+	}
+	// 只有在读取之后 center-config-current 没有被修改过，才写入新版本
+	resp, err := cli.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(configCurrentKey), "=", modRevision)).
+		Then(
+			clientv3.OpPut(configCurrentKey, string(value)),
+			clientv3.OpPut(configRevisionKey(next.Revision), string(value)),
+		).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Succeeded {
+		return nil, ErrConfigConflict
+	}
+	log.Printf("Config-Info: commit config revision %d, %s \n", next.Revision, next.Comment)
+	return next, nil
+}
+
+// listConfigRevisions 取得所有历史版本（不包含配置内容），按版本号从小到大
+func listConfigRevisions() ([]ConfigRevision, error) {
+	resp, err := cli.Get(context.Background(), configRevisionPrefix, clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	var revisions []ConfigRevision
+	for _, kv := range resp.Kvs {
+		var revision ConfigRevision
+		if err := json.Unmarshal(kv.Value, &revision); err != nil {
+			log.Printf("Config-Error: config revision format error, key: %s, err: %v \n", string(kv.Key), err)
+			continue
+		}
+		revision.ServiceMetas, revision.NodeMetas = nil, nil
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// getConfigHistory 取得某个历史版本，不存在时返回 nil
+func getConfigHistory(revision int64) (*ConfigRevision, error) {
+	resp, err := cli.Get(context.Background(), configRevisionKey(revision))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	history := &ConfigRevision{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// configWatchRetry 监听etcd中的配置出错之后，重新读取并监听的间隔
+const configWatchRetry = 5 * time.Second
+
+// initConfigStore center 启动时：etcd 中已有配置则使用etcd中的配置，否则将配置文件中的配置保存为第一个版本
+// 返回已加载的版本号，etcd 不可用时返回 0
+func (s *Scheduler) initConfigStore() int64 {
+	current, _, err := getConfigRevision()
+	if err != nil {
+		log.Printf("Config-Error: get config from etcd error, use config files, err: %v \n", err)
+		return 0
+	}
+	if current == nil {
+		current, err = commitConfig(func(serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs) (string, error) {
+			for name, servConfig := range s.serviceConfigs() {
+				serviceMetas[name] = servConfig
+			}
+			for name, node := range s.nodeConfigs() {
+				nodeMetas[name] = node
+			}
+			return "init from config files", nil
+		})
+		if err != nil {
+			log.Printf("Config-Error: save config files to etcd error, err: %v \n", err)
+			return 0
+		}
+		return current.Revision
+	}
+	if err := s.applyConfigs(current.ServiceMetas, current.NodeMetas); err != nil {
+		log.Printf("Config-Error: config revision %d in etcd is invalid, use config files, err: %v \n", current.Revision, err)
+		return 0
+	}
+	log.Printf("Config-Info: use config revision %d from etcd \n", current.Revision)
+	return current.Revision
+}
+
+// watchConfigStore 监听etcd中配置的变化，重新加载，applied 为已加载的版本号
+// 监听出错（例如监听的版本已被压缩）或者中断时，重新读取当前配置，并从读取时的etcd版本继续监听
+func (s *Scheduler) watchConfigStore(applied int64) {
+	for {
+		resp, err := cli.Get(context.Background(), configCurrentKey)
+		if err != nil {
+			log.Printf("Config-Error: get config from etcd error, retry after %v, err: %v \n", configWatchRetry, err)
+			time.Sleep(configWatchRetry)
+			continue
+		}
+		if len(resp.Kvs) > 0 {
+			applied = s.reloadConfigRevision(resp.Kvs[0].Value, applied)
+		}
+		watcher := clientv3.NewWatcher(cli)
+		for watchResponse := range watcher.Watch(context.Background(), configCurrentKey, clientv3.WithRev(resp.Header.Revision+1)) {
+			if err := watchResponse.Err(); err != nil {
+				log.Printf("Config-Error: watch config error, compact revision: %d, re-read config, err: %v \n", watchResponse.CompactRevision, err)
+				break
+			}
+			for _, event := range watchResponse.Events {
+				if event.Type != mvccpb.PUT {
+					continue
+				}
+				applied = s.reloadConfigRevision(event.Kv.Value, applied)
+			}
+		}
+		watcher.Close()
+		time.Sleep(configWatchRetry)
+	}
+}
+
+// reloadConfigRevision 加载etcd中的一个配置版本，版本号与 applied 相同时跳过，返回已加载的版本号
+func (s *Scheduler) reloadConfigRevision(value []byte, applied int64) int64 {
+	var current ConfigRevision
+	if err := json.Unmarshal(value, &current); err != nil {
+		log.Printf("Config-Error: config format error, keep the previous configs, err: %v \n", err)
+		return applied
+	}
+	if current.Revision == applied {
+		return applied
+	}
+	if err := s.applyConfigs(current.ServiceMetas, current.NodeMetas); err != nil {
+		log.Printf("Config-Error: reload config revision %d error, keep the previous configs, err: %v \n", current.Revision, err)
+		return applied
+	}
+	log.Printf("Config-Info: config revision %d reloaded, %s \n", current.Revision, current.Comment)
+	return current.Revision
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeFileChanges(t *testing.T) {
+	oldFile := brisk.AllServConfigs{
+		"hello": {ServiceName: "hello", Replica: 1},
+		"world": {ServiceName: "world", Replica: 1},
+		"gone":  {ServiceName: "gone", Replica: 1},
+	}
+	newFile := brisk.AllServConfigs{
+		"hello": {ServiceName: "hello", Replica: 1},
+		"world": {ServiceName: "world", Replica: 3},
+		"added": {ServiceName: "added", Replica: 1},
+	}
+	// etcd 中的当前配置：hello 以及 api 通过接口修改过
+	configs := brisk.AllServConfigs{
+		"hello": {ServiceName: "hello", Replica: 2},
+		"world": {ServiceName: "world", Replica: 1},
+		"gone":  {ServiceName: "gone", Replica: 1},
+		"api":   {ServiceName: "api", Replica: 1},
+	}
+
+	changed := mergeFileChanges(configs, oldFile, newFile)
+
+	assert.Equal(t, []string{"added", "gone", "world"}, changed)
+	assert.Equal(t, 2, configs["hello"].Replica, "entries unchanged in the file keep the API changes")
+	assert.Equal(t, 3, configs["world"].Replica)
+	assert.Contains(t, configs, "added")
+	assert.Contains(t, configs, "api")
+	assert.NotContains(t, configs, "gone")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// errRolloutCancelled 升级被取消
+var errRolloutCancelled = errors.New("rollout cancelled")
+
+// rolloutControl 运行中的升级的控制：暂停（当前批次完成后），继续，取消（可选回滚）
+// pause/resume/cancel 由HTTP接口调用，wait 只在升级的goroutine中调用
+type rolloutControl struct {
+	mu        sync.Mutex
+	paused    bool
+	cancelled bool
+	rollback  bool
+	// changed 控制变化时关闭并替换，等待中的升级据此被唤醒
+	changed chan struct{}
+	state   *RolloutState
+}
+
+func newRolloutControl(state *RolloutState) *rolloutControl {
+	return &rolloutControl{paused: state.Paused, changed: make(chan struct{}), state: state}
+}
+
+// update 修改控制并唤醒等待中的升级
+func (c *rolloutControl) update(change func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	change()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *rolloutControl) pause() {
+	c.update(func() { c.paused = true })
+}
+
+func (c *rolloutControl) resume() {
+	c.update(func() { c.paused = false })
+}
+
+func (c *rolloutControl) cancel(rollback bool) {
+	c.update(func() { c.cancelled, c.rollback = true, rollback })
+}
+
+// isPaused 是否已请求暂停
+func (c *rolloutControl) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// cancelRequested 是否已请求取消，以及取消后是否回滚
+func (c *rolloutControl) cancelRequested() (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cancelled, c.rollback
+}
+
+// clearCancel 取消并开始回滚之后清除取消请求，回滚过程可以再次被取消
+func (c *rolloutControl) clearCancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelled, c.rollback = false, false
+}
+
+// watch 控制变化时关闭的channel，control 为 nil 时返回 nil（永远阻塞）
+func (c *rolloutControl) watch() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.changed
+}
+
+// wait 下发下一批镜像之前调用：暂停时阻塞直到继续或取消，取消时返回 errRolloutCancelled
+// 暂停状态同步到 RolloutState，center 重启后升级仍保持暂停
+func (c *rolloutControl) wait(s *Scheduler) error {
+	if c == nil {
+		return nil
+	}
+	serviceName := c.state.ServiceName
+	for {
+		changed := c.watch()
+		if cancelled, _ := c.cancelRequested(); cancelled {
+			return errRolloutCancelled
+		}
+		paused := c.isPaused()
+		if paused != c.state.Paused {
+			c.state.Paused = paused
+			c.state.save()
+			event := Event{Type: EventRolloutResumed, Severity: SeverityInfo, ServiceName: serviceName, CommitHash: c.state.CommitHash}
+			event.Message = fmt.Sprintf("Rolling-Resumed: service: %s, commitHash: %s, rollout resumed \n", serviceName, c.state.CommitHash)
+			if paused {
+				event.Type = EventRolloutPaused
+				event.Message = fmt.Sprintf("Rolling-Paused: service: %s, commitHash: %s, rollout paused after replica %d/%d \n", serviceName, c.state.CommitHash, c.state.Index, len(c.state.Images))
+			}
+			log.Print(event.Message)
+			s.emit(event)
+		}
+		if !paused {
+			return nil
+		}
+		<-changed
+	}
+}
+
+// registerControl 为开始运行的升级注册控制
+func (s *Scheduler) registerControl(state *RolloutState) *rolloutControl {
+	control := newRolloutControl(state)
+	s.mu.Lock()
+	s.controls[state.ServiceName] = control
+	s.mu.Unlock()
+	return control
+}
+
+// rolloutControlOf 服务正在运行的升级的控制，没有时返回 nil
+func (s *Scheduler) rolloutControlOf(serviceName string) *rolloutControl {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.controls[serviceName]
+}
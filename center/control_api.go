@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// rolloutInfo 服务正在进行的升级以及等待升级的镜像事件
+type rolloutInfo struct {
+	serviceQueue
+	// Paused 已请求暂停，当前批次完成后暂停
+	Paused bool `json:"paused"`
+	// State etcd 中保存的升级状态，没有正在进行的升级时为 nil（例如正在修复副本）
+	State *RolloutState `json:"state"`
+}
+
+// initControlAPI 查看，暂停，继续，取消升级，升级只在leader中运行
+func (s *Scheduler) initControlAPI() {
+	g := s.HTTPServer.Group("/api/rollouts", s.leaderOnly)
+	g.GET("", s.listRollouts)
+	g.GET("/:name", s.getRollout)
+	g.POST("/:name/pause", s.controlRollout(func(control *rolloutControl, c echo.Context) error {
+		control.pause()
+		return nil
+	}))
+	g.POST("/:name/resume", s.controlRollout(func(control *rolloutControl, c echo.Context) error {
+		control.resume()
+		return nil
+	}))
+	// POST /api/rollouts/:name/cancel?rollback=true 取消升级并回滚已下发的副本
+	g.POST("/:name/cancel", s.controlRollout(func(control *rolloutControl, c echo.Context) error {
+		rollback := false
+		if param := c.QueryParam("rollback"); param != "" {
+			var err error
+			if rollback, err = strconv.ParseBool(param); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "rollback must be true or false")
+			}
+		}
+		control.cancel(rollback)
+		return nil
+	}))
+}
+
+// rolloutInfo 取得服务的升级信息
+func (s *Scheduler) rolloutInfo(serviceName string) (rolloutInfo, error) {
+	info := rolloutInfo{serviceQueue: s.serviceQueue(serviceName)}
+	if control := s.rolloutControlOf(serviceName); control != nil {
+		info.Paused = control.isPaused()
+	}
+	resp, err := cli.Get(context.Background(), rolloutStatePrefix+serviceName)
+	if err != nil {
+		return info, err
+	}
+	if len(resp.Kvs) > 0 {
+		info.State = &RolloutState{}
+		if err := json.Unmarshal(resp.Kvs[0].Value, info.State); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+// listRollouts 正在升级或者有等待事件的服务，按服务名排序
+func (s *Scheduler) listRollouts(c echo.Context) error {
+	infos := []rolloutInfo{}
+	for _, name := range s.busyServices() {
+		info, err := s.rolloutInfo(name)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		infos = append(infos, info)
+	}
+	return c.JSON(http.StatusOK, infos)
+}
+
+func (s *Scheduler) getRollout(c echo.Context) error {
+	name := c.Param("name")
+	if _, ok := s.serviceConfig(name); !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "service is not configured")
+	}
+	info, err := s.rolloutInfo(name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+// controlRollout 对服务正在运行的升级执行控制，返回控制之后的升级信息；没有正在运行的升级时返回 404
+func (s *Scheduler) controlRollout(apply func(control *rolloutControl, c echo.Context) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		control := s.rolloutControlOf(name)
+		if control == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "no running rollout")
+		}
+		if err := apply(control, c); err != nil {
+			return err
+		}
+		info, err := s.rolloutInfo(name)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, info)
+	}
+}
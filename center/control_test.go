@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutControlCancel(t *testing.T) {
+	control := newRolloutControl(&RolloutState{ServiceName: "hello"})
+	changed := control.watch()
+	assert.NoError(t, control.wait(nil))
+
+	control.cancel(true)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("cancel should wake up the waiting rollout")
+	}
+	assert.Equal(t, errRolloutCancelled, control.wait(nil))
+	cancelled, rollback := control.cancelRequested()
+	assert.True(t, cancelled)
+	assert.True(t, rollback)
+
+	// 取消并回滚时，回滚过程不再被取消
+	control.clearCancel()
+	assert.NoError(t, control.wait(nil))
+
+	// 没有注册控制的升级（例如修复副本）不会被暂停或取消
+	var none *rolloutControl
+	assert.Nil(t, none.watch())
+	assert.NoError(t, none.wait(nil))
+}
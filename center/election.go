@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/labstack/echo"
+)
+
+// 多个center实例通过etcd选举leader，只有leader处理镜像事件以及周期性检查
+// 选举使用的key：center-leader/"LeaseID"，值为leader对外的访问地址
+const centerElectionPrefix = "center-leader"
+
+var (
+	// CenterAddress 当前center对外的访问地址，例如 http://172.19.178.107:20000，follower 将请求转发到leader的此地址
+	CenterAddress = os.Getenv("CenterAddress")
+	// LeaderTTL leader 租约时间（秒），leader 宕机后 follower 在此时间内接管
+	LeaderTTL = os.Getenv("LeaderTTL")
+)
+
+// campaign 参与leader选举，阻塞直到成为leader
+// leader 的租约丢失后 center 直接退出，由新的leader根据etcd中的升级状态继续升级
+func (s *Scheduler) campaign() {
+	ttl, err := strconv.Atoi(LeaderTTL)
+	if err != nil || ttl <= 0 {
+		ttl = 10
+	}
+	address := CenterAddress
+	if address == "" {
+		address = fmt.Sprintf("http://%s:20000", brisk.GetHostname())
+	}
+	session, err := concurrency.NewSession(cli, concurrency.WithTTL(ttl))
+	if err != nil {
+		log.Fatalf("Leader-Error: create etcd session error, err: %v \n", err)
+	}
+	election := concurrency.NewElection(session, centerElectionPrefix)
+	s.mu.Lock()
+	s.election = election
+	s.mu.Unlock()
+	log.Printf("Leader-Info: campaign for leader, address: %s, ttl: %ds \n", address, ttl)
+	err = election.Campaign(context.Background(), address)
+	if err != nil {
+		log.Fatalf("Leader-Error: campaign error, err: %v \n", err)
+	}
+	s.mu.Lock()
+	s.isLeader = true
+	s.mu.Unlock()
+	log.Printf("Leader-Info: this center is leader now, address: %s \n", address)
+	go func() {
+		<-session.Done()
+		log.Fatalf("Leader-Error: etcd session expired, lost leadership, center exit \n")
+	}()
+}
+
+// IsLeader 当前center是否为leader
+func (s *Scheduler) IsLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isLeader
+}
+
+// leaderAddress 获取当前leader的访问地址
+func (s *Scheduler) leaderAddress() (string, error) {
+	s.mu.Lock()
+	election := s.election
+	s.mu.Unlock()
+	if election == nil {
+		return "", concurrency.ErrElectionNoLeader
+	}
+	resp, err := election.Leader(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+// leaderOnly 只能由leader处理的请求，follower 转发到leader；找不到leader时返回 503
+func (s *Scheduler) leaderOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.IsLeader() {
+			return next(c)
+		}
+		address, err := s.leaderAddress()
+		if err != nil {
+			log.Printf("Leader-Error: follower can not find leader, err: %v \n", err)
+			return c.String(http.StatusServiceUnavailable, "no leader")
+		}
+		return forwardRequest(c, strings.TrimSuffix(address, "/"))
+	}
+}
+
+// forwardRequest 将请求原样转发到 address，并返回其响应
+func forwardRequest(c echo.Context, address string) error {
+	req := c.Request()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	forward, err := http.NewRequest(req.Method, address+req.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	forward.Header = req.Header.Clone()
+	log.Printf("Leader-Info: forward request to leader, %s %s \n", req.Method, forward.URL)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(forward)
+	if err != nil {
+		log.Printf("Leader-Error: forward request to leader error, err: %v \n", err)
+		return c.String(http.StatusBadGateway, "forward to leader failed")
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return c.String(http.StatusBadGateway, "forward to leader failed")
+	}
+	return c.Blob(resp.StatusCode, resp.Header.Get(echo.HeaderContentType), respBody)
+}
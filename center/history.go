@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/rs/xid"
+)
+
+// rolloutHistoryPrefix 升级历史 center-rollout-history-"StartTime"-"ServiceName"，StartTime 为纳秒时间戳补齐为19位数字，按时间排序
+const rolloutHistoryPrefix = "center-rollout-history-"
+
+const (
+	// rolloutHistoryRetention 升级历史保留的时间，保存新的升级历史时删除更早的记录
+	rolloutHistoryRetention = 90 * 24 * time.Hour
+	// rolloutHistoryPageSize 查询升级历史时每次从etcd读取的数量
+	rolloutHistoryPageSize = 100
+)
+
+// 升级结果
+const (
+	OutcomeSuccess   = "success"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled"
+)
+
+// 回滚结果，没有回滚时为空
+const (
+	RollbackSuccess = "success"
+	RollbackFailed  = "failed"
+)
+
+// 副本启动的阶段
+const (
+	PhaseRollout  = "rollout"
+	PhaseRollback = "rollback"
+)
+
+// ReplicaRecord 升级过程中一个副本的启动结果
+type ReplicaRecord struct {
+	Phase       string    `json:"phase"` // rollout / rollback
+	Node        string    `json:"node"`
+	ImageID     string    `json:"image_id"`
+	FullName    string    `json:"full_name"`
+	ContainerID string    `json:"container_id"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+}
+
+func newReplicaRecord(phase string, feedback brisk.RollingFeedback) ReplicaRecord {
+	return ReplicaRecord{
+		Phase:       phase,
+		Node:        feedback.Node,
+		ImageID:     feedback.ImageID,
+		FullName:    feedback.FullName,
+		ContainerID: feedback.ContainerID,
+		Success:     feedback.Success,
+		Error:       feedback.Error,
+		StartTime:   feedback.StartTime,
+		EndTime:     feedback.EndTime,
+	}
+}
+
+// RolloutRecord 一次升级的历史记录
+type RolloutRecord struct {
+	ID             string          `json:"id"`
+	ServiceName    string          `json:"service_name"`
+	CommitHash     string          `json:"commit_hash"`
+	PreviousCommit string          `json:"previous_commit"`
+	Source         string          `json:"source"` // 触发升级的来源
+	Replicas       []ReplicaRecord `json:"replicas"`
+	Outcome        string          `json:"outcome"`  // success / failed / cancelled
+	Rollback       string          `json:"rollback"` // 回滚结果，没有回滚时为空
+	Failures       []string        `json:"failures"`
+	StartTime      time.Time       `json:"start_time"`
+	EndTime        time.Time       `json:"end_time"`
+}
+
+// saveRolloutRecord 升级结束后，保存升级历史
+func saveRolloutRecord(state *RolloutState) {
+	record := RolloutRecord{
+		ID:             fmt.Sprintf("%s", xid.New()),
+		ServiceName:    state.ServiceName,
+		CommitHash:     state.CommitHash,
+		PreviousCommit: state.PreviousCommit,
+		Source:         state.Source,
+		Replicas:       state.Replicas,
+		Outcome:        state.Outcome,
+		Rollback:       state.Rollback,
+		Failures:       state.Failures,
+		StartTime:      state.StartTime,
+		EndTime:        time.Now(),
+	}
+	// 升级前版本的状态中没有开始时间
+	if record.StartTime.IsZero() {
+		record.StartTime = state.CreateTime
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Rolling-History-Error: rollout record marshal error, service: %s, err: %v \n", state.ServiceName, err)
+		return
+	}
+	if _, err := cli.Put(context.Background(), rolloutRecordKey(record.StartTime, record.ServiceName), string(value)); err != nil {
+		log.Printf("Rolling-History-Error: put rollout record error, service: %s, err: %v \n", state.ServiceName, err)
+	}
+	pruneRolloutRecords(time.Now().Add(-rolloutHistoryRetention))
+}
+
+// rolloutRecordKey 升级历史的key，按照字符串排序即按照开始时间排序
+func rolloutRecordKey(startTime time.Time, serviceName string) string {
+	return fmt.Sprintf("%s%019d-%s", rolloutHistoryPrefix, startTime.UnixNano(), serviceName)
+}
+
+// pruneRolloutRecords 删除开始时间早于 before 的升级历史
+func pruneRolloutRecords(before time.Time) {
+	end := fmt.Sprintf("%s%019d", rolloutHistoryPrefix, before.UnixNano())
+	resp, err := cli.Delete(context.Background(), rolloutHistoryPrefix, clientv3.WithRange(end))
+	if err != nil {
+		log.Printf("Rolling-History-Error: delete rollout records before %v error, err: %v \n", before.Format("2006-01-02 15:04:05"), err)
+		return
+	}
+	if resp.Deleted > 0 {
+		log.Printf("Rolling-History-Info: %d rollout records before %v deleted \n", resp.Deleted, before.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// listRolloutRecords 查询开始时间在 [from, to) 之间的升级历史，按开始时间从新到旧排序
+// serviceName 为空时查询所有服务，limit <= 0 时不限制数量
+// 从新到旧每次读取 rolloutHistoryPageSize 条，取够 limit 条之后不再读取
+func listRolloutRecords(serviceName string, from, to time.Time, limit int) ([]RolloutRecord, error) {
+	start := fmt.Sprintf("%s%019d", rolloutHistoryPrefix, from.UnixNano())
+	end := fmt.Sprintf("%s%019d", rolloutHistoryPrefix, to.UnixNano())
+	records := []RolloutRecord{}
+	for {
+		resp, err := cli.Get(context.Background(), start, clientv3.WithRange(end),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend), clientv3.WithLimit(rolloutHistoryPageSize))
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range resp.Kvs {
+			var record RolloutRecord
+			if err := json.Unmarshal(kv.Value, &record); err != nil {
+				log.Printf("Rolling-History-Error: rollout record format error, key: %s, err: %v \n", string(kv.Key), err)
+				continue
+			}
+			if serviceName != "" && record.ServiceName != serviceName {
+				continue
+			}
+			records = append(records, record)
+			if limit > 0 && len(records) >= limit {
+				return records, nil
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return records, nil
+		}
+		// 下一页为比本页最后一个 key 更早的记录
+		end = string(resp.Kvs[len(resp.Kvs)-1].Key)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// initHistoryAPI 查询升级历史
+// GET /api/history?service=hello&from=2019-01-01T00:00:00Z&to=2019-02-01T00:00:00Z&limit=20
+func (s *Scheduler) initHistoryAPI() {
+	s.HTTPServer.GET("/api/history", listRolloutHistory)
+	s.HTTPServer.GET("/api/history/:service", listRolloutHistory)
+}
+
+// listRolloutHistory from 默认为最早，to 默认为当前时间，时间格式为 RFC3339
+func listRolloutHistory(c echo.Context) error {
+	serviceName := c.Param("service")
+	if serviceName == "" {
+		serviceName = c.QueryParam("service")
+	}
+	from, err := timeParam(c, "from", time.Unix(0, 0))
+	if err != nil {
+		return err
+	}
+	to, err := timeParam(c, "to", time.Now())
+	if err != nil {
+		return err
+	}
+	limit := 0
+	if param := c.QueryParam("limit"); param != "" {
+		if limit, err = strconv.Atoi(param); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a number")
+		}
+	}
+	records, err := listRolloutRecords(serviceName, from, to, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+// timeParam 解析 RFC3339 格式的时间参数，没有时返回 defaultValue
+func timeParam(c echo.Context, name string, defaultValue time.Time) (time.Time, error) {
+	param := c.QueryParam(name)
+	if param == "" {
+		return defaultValue, nil
+	}
+	t, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return t, echo.NewHTTPError(http.StatusBadRequest, name+" must be RFC3339 time, e.g. 2019-01-02T15:04:05+08:00")
+	}
+	return t, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutRecordKey(t *testing.T) {
+	start := time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)
+	keys := []string{
+		rolloutRecordKey(start.Add(time.Hour), "a"),
+		rolloutRecordKey(start, "zzz"),
+		rolloutRecordKey(time.Unix(1, 0), "hello"),
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{
+		rolloutHistoryPrefix + "0000000001000000000-hello",
+		rolloutHistoryPrefix + "1546441445000000000-zzz",
+		rolloutHistoryPrefix + "1546445045000000000-a",
+	}, keys)
+}
+
+func TestTimeParam(t *testing.T) {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest("GET", "/api/history?from=2019-01-02T15:04:05%2B08:00&to=yesterday", nil), httptest.NewRecorder())
+	defaultValue := time.Unix(0, 0)
+
+	from, err := timeParam(c, "from", defaultValue)
+	assert.NoError(t, err)
+	assert.True(t, from.Equal(time.Date(2019, 1, 2, 7, 4, 5, 0, time.UTC)))
+
+	_, err = timeParam(c, "to", defaultValue)
+	assert.Error(t, err)
+
+	missing, err := timeParam(c, "limit", defaultValue)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultValue, missing)
+}
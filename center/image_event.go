@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/labstack/echo"
+)
+
+// imageEventPrefix 已接受的镜像事件 center-image-event-"ServiceName"/"CommitHash"，同一版本只升级一次
+// 服务名不能包含 /，以第一个 / 分隔服务名与版本号
+const imageEventPrefix = "center-image-event-"
+
+// imageEventTTL 已接受的镜像事件记录的租约时间，超过后同一版本可以再次推送
+const imageEventTTL = 24 * time.Hour
+
+// signatureHeader 请求体的 HMAC-SHA256 签名，格式为 sha256="hex"
+const signatureHeader = "X-Brisk-Signature"
+
+// ErrImageEventDuplicated 同一服务的同一版本已经接受过
+var ErrImageEventDuplicated = errors.New("image event has been accepted")
+
+// InvalidImageEventError 镜像事件没有通过校验
+type InvalidImageEventError struct {
+	Msg string
+}
+
+func (e *InvalidImageEventError) Error() string {
+	return "invalid image event: " + e.Msg
+}
+
+// postImageEvent POST /api/brisk，校验签名以及镜像事件后交给 ImageEventChan
+func (s *Scheduler) postImageEvent(c echo.Context) error {
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !verifySignature(body, c.Request().Header.Get(signatureHeader)) {
+		log.Printf("Event-Error: image event signature mismatch, remote: %s \n", c.RealIP())
+		return echo.NewHTTPError(http.StatusUnauthorized, "signature mismatch")
+	}
+	var event ServiceImageEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	event.Source = "api"
+	return imageEventResponse(c, s.acceptImageEvent(event))
+}
+
+// verifySignature 校验请求体的签名 sha256=hex(HMAC-SHA256(BriskSecret, body))
+func verifySignature(body []byte, signature string) bool {
+	if BriskSecret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(BriskSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// acceptImageEvent 校验镜像事件，记录到etcd后放入 ImageEventChan
+// 服务必须在服务列表中；同一服务的同一版本只接受一次
+func (s *Scheduler) acceptImageEvent(event ServiceImageEvent) error {
+	if event.ServiceName == "" || event.CommitHash == "" {
+		return &InvalidImageEventError{"service_name and commit_hash are required"}
+	}
+	if _, ok := s.serviceConfig(event.ServiceName); !ok {
+		return &InvalidImageEventError{"service " + event.ServiceName + " is not configured"}
+	}
+	if event.CreateTime.IsZero() {
+		event.CreateTime = time.Now()
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// 只有key不存在时才写入，多次推送同一版本只有第一次成功；记录在 imageEventTTL 之后过期
+	lease, err := cli.Grant(context.Background(), int64(imageEventTTL/time.Second))
+	if err != nil {
+		return err
+	}
+	key := imageEventKey(event.ServiceName, event.CommitHash)
+	resp, err := cli.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		cli.Revoke(context.Background(), lease.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		log.Printf("Event-Info: image event duplicated, service: %s, commitHash: %s \n", event.ServiceName, event.CommitHash)
+		return ErrImageEventDuplicated
+	}
+	log.Printf("Event-Info: image event accepted, service: %s, commitHash: %s, createTime: %v \n", event.ServiceName, event.CommitHash, event.CreateTime)
+	s.ImageEventChan <- event
+	return nil
+}
+
+func imageEventKey(serviceName, commitHash string) string {
+	return imageEventPrefix + serviceName + "/" + commitHash
+}
+
+// forgetImageEvent 删除已接受的镜像事件记录，同一版本可以再次推送
+func forgetImageEvent(serviceName, commitHash string) {
+	if _, err := cli.Delete(context.Background(), imageEventKey(serviceName, commitHash)); err != nil {
+		log.Printf("Event-Error: delete image event error, service: %s, commitHash: %s, err: %v \n", serviceName, commitHash, err)
+	}
+}
+
+// settleImageEvents 升级结束后整理服务已接受的镜像事件：
+// 升级失败或取消时删除本版本的记录，修复问题之后可以重新推送；
+// 升级成功时删除其他已经结束的版本的记录（队列中等待的版本除外），之后可以重新部署之前的版本，例如 A -> B -> A
+func (s *Scheduler) settleImageEvents(serviceName, commitHash string, success bool) {
+	if !success {
+		forgetImageEvent(serviceName, commitHash)
+		return
+	}
+	resp, err := cli.Get(context.Background(), imageEventPrefix+serviceName+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		log.Printf("Event-Error: get image events error, service: %s, err: %v \n", serviceName, err)
+		return
+	}
+	keep := map[string]bool{imageEventKey(serviceName, commitHash): true}
+	s.mu.Lock()
+	for _, queued := range s.RollingServQueue[serviceName] {
+		keep[imageEventKey(serviceName, queued.CommitHash)] = true
+	}
+	s.mu.Unlock()
+	for _, kv := range resp.Kvs {
+		if !keep[string(kv.Key)] {
+			cli.Delete(context.Background(), string(kv.Key))
+		}
+	}
+}
+
+// imageEventResponse 接受镜像事件的响应：成功时返回 accepted，失败时根据错误类型返回 4xx/5xx
+func imageEventResponse(c echo.Context, err error) error {
+	switch {
+	case err == nil:
+		return c.String(http.StatusOK, "accepted")
+	case err == ErrImageEventDuplicated:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if _, ok := err.(*InvalidImageEventError); ok {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
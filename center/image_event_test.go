@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sign 按照 X-Brisk-Signature 的格式签名请求体
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	secret := BriskSecret
+	defer func() { BriskSecret = secret }()
+	body := []byte(`{"service_name":"hello","commit_hash":"abc"}`)
+
+	BriskSecret = "s3cret"
+	assert.True(t, verifySignature(body, sign("s3cret", body)))
+	assert.False(t, verifySignature(body, sign("other", body)))
+	assert.False(t, verifySignature([]byte(`{"service_name":"hello"}`), sign("s3cret", body)))
+	assert.False(t, verifySignature(body, "sha256=not-hex"))
+	assert.False(t, verifySignature(body, hex.EncodeToString([]byte("no prefix"))))
+
+	// 未配置密钥时拒绝所有镜像事件
+	BriskSecret = ""
+	assert.False(t, verifySignature(body, sign("", body)))
+}
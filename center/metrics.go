@@ -0,0 +1,83 @@
+package main
+
+import (
+	"time"
+
+	"brisk"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus 指标，升级以及检查只在leader中进行，follower 的指标没有数据
+var (
+	rolloutsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "brisk_center_rollouts_total",
+		Help: "Number of finished rollouts by service and outcome.",
+	}, []string{"service", "outcome"})
+	rolloutDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "brisk_center_rollout_duration_seconds",
+		Help: "Duration of finished rollouts by service and outcome, including canary soak and rollback.",
+		// 10s ~ 5.7h
+		Buckets: prometheus.ExponentialBuckets(10, 2, 12),
+	}, []string{"service", "outcome"})
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "brisk_center_queue_depth",
+		Help: "Number of image events waiting for the running rollout of the service.",
+	}, []string{"service"})
+	expectedReplicas = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "brisk_center_service_replicas_expected",
+		Help: "Configured number of replicas of the service.",
+	}, []string{"service"})
+	actualReplicas = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "brisk_center_service_replicas_actual",
+		Help: "Number of replicas of the service running successfully on keepers.",
+	}, []string{"service"})
+	liveKeepers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "brisk_center_keepers",
+		Help: "Number of keepers registered in etcd.",
+	})
+	registerMismatches = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "brisk_center_register_mismatches",
+		Help: "Number of running replicas of the service that are not registered, found by the last registration check.",
+	}, []string{"service"})
+)
+
+// initMetrics GET /metrics
+func (s *Scheduler) initMetrics() {
+	s.HTTPServer.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+}
+
+// observeRollout 升级结束时记录升级次数以及耗时
+func observeRollout(state *RolloutState) {
+	outcome := state.Outcome
+	if outcome == "" {
+		outcome = OutcomeFailed
+	}
+	rolloutsTotal.WithLabelValues(state.ServiceName, outcome).Inc()
+	if !state.StartTime.IsZero() {
+		rolloutDuration.WithLabelValues(state.ServiceName, outcome).Observe(time.Since(state.StartTime).Seconds())
+	}
+}
+
+// observeReplicas 记录每个服务期望以及实际运行的副本数量，不在服务列表中的服务不再输出
+func observeReplicas(serviceMetas brisk.AllServConfigs, succImages map[string][]brisk.NodeImage) {
+	expectedReplicas.Reset()
+	actualReplicas.Reset()
+	for name, servConfig := range serviceMetas {
+		expectedReplicas.WithLabelValues(name).Set(float64(servConfig.Replica))
+		actualReplicas.WithLabelValues(name).Set(float64(len(succImages[name])))
+	}
+}
+
+// observeRegisterMismatches 记录注册检查发现的未注册副本数量
+func observeRegisterMismatches(problems []Event) {
+	registerMismatches.Reset()
+	for _, e := range problems {
+		if e.Type == EventRegisterFailed {
+			registerMismatches.WithLabelValues(e.ServiceName).Inc()
+		}
+	}
+}
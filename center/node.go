@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// nodeCordonPrefix 停止调度的节点 center-node-cordon-"HostName"，值为 NodeCordon
+const nodeCordonPrefix = "center-node-cordon-"
+
+// drainRetryInterval 驱逐时等待服务的升级/修复结束的间隔
+const drainRetryInterval = 10 * time.Second
+
+// 节点驱逐的状态
+const (
+	DrainRunning   = "running"
+	DrainSucceeded = "succeeded"
+	DrainFailed    = "failed"
+)
+
+// NodeCordon 停止调度的节点：升级以及修复不再在此节点上启动副本，已运行的副本不受影响；驱逐时将副本逐个迁移到其他节点
+type NodeCordon struct {
+	HostName   string    `json:"host_name"`
+	Reason     string    `json:"reason"`
+	CordonTime time.Time `json:"cordon_time"`
+	// Drain 驱逐状态，没有驱逐时为空；Drained 已迁移的服务；DrainError 驱逐失败的原因
+	Drain      string   `json:"drain,omitempty"`
+	Drained    []string `json:"drained,omitempty"`
+	DrainError string   `json:"drain_error,omitempty"`
+}
+
+func (c *NodeCordon) save() error {
+	value, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = cli.Put(context.Background(), nodeCordonPrefix+c.HostName, string(value))
+	if err != nil {
+		log.Printf("Node-Error: put node cordon error, node: %s, err: %v \n", c.HostName, err)
+	}
+	return err
+}
+
+// getCordons 所有停止调度的节点，key 为 HostName
+func getCordons() (map[string]NodeCordon, error) {
+	cordons := make(map[string]NodeCordon)
+	resp, err := cli.Get(context.Background(), nodeCordonPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range resp.Kvs {
+		var cordon NodeCordon
+		if err := json.Unmarshal(kv.Value, &cordon); err != nil {
+			log.Printf("Node-Error: %s format error, err: %v \n", string(kv.Key), err)
+			continue
+		}
+		cordons[cordon.HostName] = cordon
+	}
+	return cordons, nil
+}
+
+// getCordon 节点是否停止调度
+func getCordon(hostName string) (*NodeCordon, error) {
+	resp, err := cli.Get(context.Background(), nodeCordonPrefix+hostName)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	var cordon NodeCordon
+	if err := json.Unmarshal(resp.Kvs[0].Value, &cordon); err != nil {
+		return nil, err
+	}
+	return &cordon, nil
+}
+
+// schedulableNodes 可以调度的节点：节点配置中去掉停止调度的节点
+func (s *Scheduler) schedulableNodes() brisk.NodeConfigs {
+	nodeMetas := s.nodeConfigs()
+	cordons, err := getCordons()
+	if err != nil {
+		log.Printf("Node-Error: get node cordons error, use all nodes, err: %v \n", err)
+		return nodeMetas
+	}
+	nodes := make(brisk.NodeConfigs)
+	for name, node := range nodeMetas {
+		if _, ok := cordons[name]; ok {
+			continue
+		}
+		nodes[name] = node
+	}
+	return nodes
+}
+
+// cordonNode 节点停止调度，已经停止调度时只修改原因
+func (s *Scheduler) cordonNode(hostName, reason string) (*NodeCordon, error) {
+	cordon, err := getCordon(hostName)
+	if err != nil {
+		return nil, err
+	}
+	if cordon == nil {
+		cordon = &NodeCordon{HostName: hostName, CordonTime: time.Now()}
+		msg := fmt.Sprintf("Node-Cordon: node: %s, reason: %s \n", hostName, reason)
+		log.Print(msg)
+		s.emit(Event{Type: EventNodeCordoned, Severity: SeverityWarning, Node: hostName, Message: msg})
+		s.flushEvents("", "Node-Info,node: "+hostName)
+	}
+	if reason != "" {
+		cordon.Reason = reason
+	}
+	return cordon, cordon.save()
+}
+
+// uncordonNode 节点恢复调度，正在进行的驱逐在当前服务迁移完成后停止
+func (s *Scheduler) uncordonNode(hostName string) error {
+	if _, err := cli.Delete(context.Background(), nodeCordonPrefix+hostName); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Node-Uncordon: node: %s \n", hostName)
+	log.Print(msg)
+	s.emit(Event{Type: EventNodeUncordoned, Severity: SeverityInfo, Node: hostName, Message: msg})
+	s.flushEvents("", "Node-Info,node: "+hostName)
+	return nil
+}
+
+// startDrain 节点停止调度并开始驱逐，已经在驱逐时返回 false
+func (s *Scheduler) startDrain(hostName, reason string) (*NodeCordon, bool, error) {
+	s.mu.Lock()
+	if s.draining[hostName] {
+		s.mu.Unlock()
+		cordon, err := getCordon(hostName)
+		return cordon, false, err
+	}
+	s.draining[hostName] = true
+	s.mu.Unlock()
+	cordon, err := s.cordonNode(hostName, reason)
+	if err == nil {
+		cordon.Drain, cordon.Drained, cordon.DrainError = DrainRunning, nil, ""
+		err = cordon.save()
+	}
+	if err != nil {
+		s.mu.Lock()
+		delete(s.draining, hostName)
+		s.mu.Unlock()
+		return nil, false, err
+	}
+	go s.drainNode(*cordon)
+	return cordon, true, nil
+}
+
+// resumeDrains 成为leader之后，继续etcd中记录的未完成的驱逐
+func (s *Scheduler) resumeDrains() {
+	cordons, err := getCordons()
+	if err != nil {
+		log.Printf("Node-Error: get node cordons error, err: %v \n", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, cordon := range cordons {
+		if cordon.Drain == DrainRunning && !s.draining[name] {
+			log.Printf("Node-Info: resume draining node %s \n", name)
+			s.draining[name] = true
+			go s.drainNode(cordon)
+		}
+	}
+}
+
+// drainNode 将节点上 keeper-"HostName"-image 中的每个副本逐个迁移：先在其他可调度的节点上启动副本，成功后再要求节点上的keeper停止原来的副本
+// 迁移一个服务期间与该服务的升级/修复互斥；任一服务迁移失败则停止驱逐，节点保持停止调度
+func (s *Scheduler) drainNode(cordon NodeCordon) {
+	hostName := cordon.HostName
+	defer func() {
+		s.mu.Lock()
+		delete(s.draining, hostName)
+		s.mu.Unlock()
+	}()
+	msg := fmt.Sprintf("Node-Drain: node: %s, drain started \n", hostName)
+	log.Print(msg)
+	s.emit(Event{Type: EventDrainStarted, Severity: SeverityWarning, Node: hostName, Message: msg})
+	images, err := getNodeImages(hostName)
+	var names []string
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if contains(cordon.Drained, name) {
+			continue
+		}
+		serviceName := brisk.ReplicaService(name)
+		// 驱逐期间节点恢复调度，停止驱逐
+		if current, getErr := getCordon(hostName); getErr != nil || current == nil {
+			log.Printf("Node-Info: node %s is uncordoned, drain stopped \n", hostName)
+			return
+		}
+		s.lockService(serviceName)
+		err = s.drainService(hostName, name)
+		s.releaseService(serviceName)
+		s.flushEvents(serviceName, fmt.Sprintf("%s,service-name: %s", "Node-Drain-Info", serviceName))
+		if err != nil {
+			break
+		}
+		cordon.Drained = append(cordon.Drained, name)
+		cordon.save()
+	}
+	event := Event{Type: EventDrainSucceeded, Severity: SeverityInfo, Node: hostName}
+	cordon.Drain = DrainSucceeded
+	if err != nil {
+		cordon.Drain, cordon.DrainError = DrainFailed, err.Error()
+		event.Type, event.Severity = EventDrainFailed, SeverityError
+		msg = fmt.Sprintf("Node-Drain-Fail: node: %s, drained: %v, err: %v \n", hostName, cordon.Drained, err)
+	} else {
+		msg = fmt.Sprintf("Node-Drain-Successful: node: %s, drained: %v \n", hostName, cordon.Drained)
+	}
+	log.Print(msg)
+	event.Message = msg
+	s.emit(event)
+	s.flushEvents("", "Node-Info,node: "+hostName)
+	// 驱逐期间节点恢复调度时不再写入
+	if current, getErr := getCordon(hostName); getErr == nil && current != nil {
+		cordon.save()
+	}
+}
+
+// drainService 将节点上的服务副本（key 为 replica）迁移到其他可调度的节点
+// 服务已不在服务列表中，或其他节点上的副本已足够时，只停止节点上的副本
+func (s *Scheduler) drainService(hostName, replica string) error {
+	serviceName := brisk.ReplicaService(replica)
+	keeperHost, err := getAllNolKeeper()
+	if err != nil {
+		return err
+	}
+	nodeImages := getAllNodeImages(keeperHost, "Drain")
+	nodeImage, ok := nodeImages[hostName][replica]
+	if !ok {
+		return nil
+	}
+	if servConfig, ok := s.serviceConfig(serviceName); ok {
+		// 节点上该服务的所有副本都会被停止（包括之前已下发停止但 keeper 还没有处理的），都不计算，缺少的副本在其他可调度的节点上启动
+		for _, key := range nodeImages[hostName].Replicas(serviceName) {
+			delete(nodeImages[hostName], key)
+		}
+		plan, err := planRepair(servConfig, s.serviceConfigs(), s.schedulableNodes(), nodeImages)
+		if err != nil {
+			return fmt.Errorf("service %s: %v", serviceName, err)
+		}
+		if len(plan.Start) > 0 {
+			images, err := s.startReplicas(plan)
+			for _, d := range images {
+				cli.Delete(context.Background(), brisk.RollingFeedbackPrefix+d.ID)
+			}
+			if err != nil {
+				msg := fmt.Sprintf("Node-Drain-Error: service: %s, node: %s, start replica on other nodes error, err: %v \n", serviceName, hostName, err)
+				log.Print(msg)
+				s.emit(Event{Type: EventDrainFailed, Severity: SeverityError, ServiceName: serviceName, Node: hostName, Message: msg})
+				return fmt.Errorf("service %s: %v", serviceName, err)
+			}
+		}
+	}
+	stop := brisk.StopImage{ServiceName: serviceName, ContainerID: nodeImage.ContainerID, Reason: "node drained", Replica: replica}
+	if err := putStopImage(hostName, stop); err != nil {
+		return fmt.Errorf("service %s: %v", serviceName, err)
+	}
+	msg := fmt.Sprintf("Node-Drain: service: %s, replica: %s moved off node: %s, containerID: %s \n", serviceName, replica, hostName, nodeImage.ContainerID)
+	log.Print(msg)
+	s.emit(Event{Type: EventReplicaMoved, Severity: SeverityInfo, ServiceName: serviceName, Node: hostName, Message: msg})
+	return nil
+}
+
+// lockService 等待服务的升级/修复结束，然后标记服务正在处理，结束后调用 releaseService
+func (s *Scheduler) lockService(serviceName string) {
+	for {
+		s.mu.Lock()
+		if !s.RollingServices[serviceName] {
+			s.RollingServices[serviceName] = true
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		log.Printf("Node-Info: service %s is rolling, wait \n", serviceName)
+		time.Sleep(drainRetryInterval)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"brisk"
+
+	"github.com/labstack/echo"
+)
+
+// nodeInfo 节点配置，keeper 是否运行，以及是否停止调度
+type nodeInfo struct {
+	Config brisk.NodeConfig `json:"config"`
+	Keeper bool             `json:"keeper"`
+	// Cordon 停止调度以及驱逐的状态，可以调度时为 nil
+	Cordon *NodeCordon `json:"cordon"`
+}
+
+// initNodeAPI 节点停止调度，恢复调度，驱逐；驱逐只在leader中运行
+func (s *Scheduler) initNodeAPI() {
+	g := s.HTTPServer.Group("/api/nodes", s.leaderOnly)
+	g.GET("", s.listNodes)
+	g.GET("/:name", s.getNode)
+	g.POST("/:name/cordon", s.nodeAction(func(c echo.Context, name string) error {
+		_, err := s.cordonNode(name, c.QueryParam("reason"))
+		return err
+	}))
+	g.POST("/:name/uncordon", s.nodeAction(func(c echo.Context, name string) error {
+		return s.uncordonNode(name)
+	}))
+	// POST /api/nodes/:name/drain?reason= 停止调度并逐个迁移节点上的副本，已经在驱逐时返回 409
+	g.POST("/:name/drain", s.nodeAction(func(c echo.Context, name string) error {
+		_, started, err := s.startDrain(name, c.QueryParam("reason"))
+		if err != nil {
+			return err
+		}
+		if !started {
+			return echo.NewHTTPError(http.StatusConflict, "node is draining")
+		}
+		return nil
+	}))
+}
+
+// nodeInfos 所有配置的节点，按 HostName 排序
+func (s *Scheduler) nodeInfos() ([]nodeInfo, error) {
+	cordons, err := getCordons()
+	if err != nil {
+		return nil, err
+	}
+	keeperHost, _ := getAllNolKeeper()
+	var infos []nodeInfo
+	for name, node := range s.nodeConfigs() {
+		info := nodeInfo{Config: node, Keeper: contains(keeperHost, name)}
+		if cordon, ok := cordons[name]; ok {
+			info.Cordon = &cordon
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Config.HostName < infos[j].Config.HostName
+	})
+	return infos, nil
+}
+
+func (s *Scheduler) listNodes(c echo.Context) error {
+	infos, err := s.nodeInfos()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if infos == nil {
+		infos = []nodeInfo{}
+	}
+	return c.JSON(http.StatusOK, infos)
+}
+
+func (s *Scheduler) getNode(c echo.Context) error {
+	name := c.Param("name")
+	infos, err := s.nodeInfos()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	for _, info := range infos {
+		if info.Config.HostName == name {
+			return c.JSON(http.StatusOK, info)
+		}
+	}
+	return echo.NewHTTPError(http.StatusNotFound, "node is not configured")
+}
+
+// nodeAction 对配置中的节点执行操作，返回操作之后的节点信息
+func (s *Scheduler) nodeAction(apply func(c echo.Context, name string) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		if _, ok := s.nodeConfigs()[name]; !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "node is not configured")
+		}
+		if err := apply(c, name); err != nil {
+			if _, ok := err.(*echo.HTTPError); ok {
+				return err
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return s.getNode(c)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"brisk"
+
+	"github.com/labstack/echo"
+)
+
+// 通知事件的级别
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// severityLevels 级别的高低，用于路由匹配
+var severityLevels = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityError: 2}
+
+// 通知事件的类型
+const (
+	EventImageDispatched    = "image-dispatched"    // 镜像已下发到etcd
+	EventDispatchFailed     = "dispatch-failed"     // 镜像下发失败
+	EventReplicaSucceeded   = "replica-succeeded"   // keeper 反馈副本启动成功
+	EventReplicaFailed      = "replica-failed"      // keeper 反馈副本启动失败
+	EventFeedbackTimeout    = "feedback-timeout"    // 等待keeper反馈超时
+	EventRolloutStarted     = "rollout-started"     // 镜像设计完成，开始升级
+	EventRolloutFailed      = "rollout-failed"      // 升级无法开始，例如镜像设计失败
+	EventRolloutSucceeded   = "rollout-succeeded"   // 所有副本升级成功
+	EventRolloutCompleted   = "rollout-completed"   // 升级结束
+	EventRolloutResumed     = "rollout-resumed"     // center 重启后继续，或暂停后继续
+	EventRolloutPaused      = "rollout-paused"      // 升级暂停
+	EventRolloutCancelled   = "rollout-cancelled"   // 升级取消
+	EventCanaryStarted      = "canary-started"      // 开始观察金丝雀副本
+	EventCanaryPassed       = "canary-passed"       // 金丝雀副本观察通过
+	EventCanaryFailed       = "canary-failed"       // 金丝雀副本检查失败
+	EventRollbackStarted    = "rollback-started"    // 开始回滚
+	EventRollbackSucceeded  = "rollback-succeeded"  // 回滚成功
+	EventRollbackFailed     = "rollback-failed"     // 回滚失败
+	EventReconcileStarted   = "reconcile-started"   // 开始修复副本数量不正确的服务
+	EventReconcileSucceeded = "reconcile-succeeded" // 缺少的副本启动成功
+	EventReconcileFailed    = "reconcile-failed"    // 修复失败
+	EventReplicaStopped     = "replica-stopped"     // 要求keeper停止多余的副本
+	EventRegisterFailed     = "register-failed"     // 服务运行但没有注册
+	EventCheckFailed        = "check-failed"        // 周期性检查本身出错
+	EventAlertResolved      = "alert-resolved"      // 周期性检查发现的问题已恢复
+	EventQueueChanged       = "queue-changed"       // 镜像事件队列变化，只发送给事件流
+	EventNodeCordoned       = "node-cordoned"       // 节点停止调度
+	EventNodeUncordoned     = "node-uncordoned"     // 节点恢复调度
+	EventDrainStarted       = "drain-started"       // 开始驱逐节点上的副本
+	EventReplicaMoved       = "replica-moved"       // 副本已迁移到其他节点，节点上的副本已要求停止
+	EventDrainSucceeded     = "drain-succeeded"     // 节点上的副本全部迁移
+	EventDrainFailed        = "drain-failed"        // 驱逐失败
+)
+
+// Event 通知事件
+type Event struct {
+	Type        string `json:"type"`
+	Severity    string `json:"severity"`
+	ServiceName string `json:"service_name"`
+	CommitHash  string `json:"commit_hash,omitempty"`
+	// TargetCommit 回滚的目标版本（升级前的版本），CommitHash 为本次升级的版本
+	TargetCommit string    `json:"target_commit,omitempty"`
+	Node         string    `json:"node,omitempty"`
+	Port         string    `json:"port,omitempty"` // 副本的端口，区分同一节点上的多个副本
+	Message      string    `json:"message"`
+	Time         time.Time `json:"time"`
+}
+
+// Notification 发送给通知方式的一次通知，包含一组事件
+type Notification struct {
+	Subject     string `json:"subject"`
+	ServiceName string `json:"service_name"`
+	// Severity 事件中最高的级别
+	Severity string    `json:"severity"`
+	Events   []Event   `json:"events"`
+	Time     time.Time `json:"time"`
+}
+
+// newNotification 根据事件生成通知
+func newNotification(subject, serviceName string, events []Event) Notification {
+	n := Notification{Subject: subject, ServiceName: serviceName, Severity: SeverityInfo, Events: events, Time: time.Now()}
+	for _, e := range events {
+		if severityLevels[e.Severity] > severityLevels[n.Severity] {
+			n.Severity = e.Severity
+		}
+	}
+	return n
+}
+
+// text 通知的文本形式，邮件以及机器人使用
+func (n Notification) text() string {
+	var b strings.Builder
+	for _, e := range n.Events {
+		fmt.Fprintf(&b, "%s [%s] %s: %s\n", e.Time.Format("2006-01-02 15:04:05"), e.Severity, e.Type, e.Message)
+	}
+	return b.String()
+}
+
+// Notifier 通知方式
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// smtpNotifier 邮件通知
+type smtpNotifier struct {
+	config brisk.SMTPConfig
+	to     []string
+}
+
+func (m *smtpNotifier) Notify(n Notification) error {
+	if len(m.to) == 0 {
+		return errors.New("no mail addressees")
+	}
+	return m.config.SendMailTLS(m.to, n.Subject, n.text())
+}
+
+// webhookNotifier 通用 JSON webhook，请求体为 Notification
+type webhookNotifier struct {
+	url string
+}
+
+func (w *webhookNotifier) Notify(n Notification) error {
+	return postJSON(w.url, n)
+}
+
+// chatNotifier 聊天机器人，发送文本消息
+type chatNotifier struct {
+	kind string
+	url  string
+}
+
+func (c *chatNotifier) Notify(n Notification) error {
+	content := n.Subject + "\n" + n.text()
+	if c.kind == brisk.NotifierSlack {
+		return postJSON(c.url, map[string]interface{}{"text": content})
+	}
+	// 钉钉与企业微信机器人的文本消息格式相同
+	return postJSON(c.url, map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": content},
+	})
+}
+
+// postJSON 以 JSON 格式 POST payload，响应不是 2xx 时返回错误
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, echo.MIMEApplicationJSONCharsetUTF8, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post %s returns %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyRouter 按服务以及级别选择通知方式
+type notifyRouter struct {
+	notifiers map[string]Notifier
+	routes    []brisk.NotifyRoute
+	// repeatInterval 周期性检查发现的问题持续存在时重复通知的间隔
+	repeatInterval time.Duration
+}
+
+// newNotifyRouter 根据通知配置生成通知方式，邮件收件人为空时使用 mailAddressees
+func newNotifyRouter(config brisk.NotifyConfigs, mailAddressees brisk.MailAddressees) (*notifyRouter, error) {
+	r := &notifyRouter{notifiers: make(map[string]Notifier), routes: config.Routes, repeatInterval: defaultRepeatInterval}
+	if config.RepeatInterval < 0 {
+		return nil, fmt.Errorf("repeat_interval %d is negative", config.RepeatInterval)
+	}
+	if config.RepeatInterval > 0 {
+		r.repeatInterval = time.Duration(config.RepeatInterval) * time.Second
+	}
+	for _, nc := range config.Notifiers {
+		if _, ok := r.notifiers[nc.Name]; ok || nc.Name == "" {
+			return nil, fmt.Errorf("notifier name %q is empty or duplicated", nc.Name)
+		}
+		switch nc.Type {
+		case brisk.NotifierSMTP:
+			if config.SMTP.Host == "" || config.SMTP.Username == "" {
+				return nil, fmt.Errorf("notifier %s: smtp host and username are required", nc.Name)
+			}
+			to := nc.To
+			if len(to) == 0 {
+				for _, address := range mailAddressees {
+					to = append(to, address)
+				}
+			}
+			r.notifiers[nc.Name] = &smtpNotifier{config: config.SMTP, to: to}
+		case brisk.NotifierWebhook:
+			r.notifiers[nc.Name] = &webhookNotifier{url: nc.URL}
+		case brisk.NotifierSlack, brisk.NotifierDingTalk, brisk.NotifierWeCom:
+			r.notifiers[nc.Name] = &chatNotifier{kind: nc.Type, url: nc.URL}
+		default:
+			return nil, fmt.Errorf("notifier %s: unknown type %q", nc.Name, nc.Type)
+		}
+		if nc.Type != brisk.NotifierSMTP && nc.URL == "" {
+			return nil, fmt.Errorf("notifier %s: url is empty", nc.Name)
+		}
+	}
+	for _, route := range config.Routes {
+		if _, ok := severityLevels[route.Severity]; !ok && route.Severity != "" {
+			return nil, fmt.Errorf("route: unknown severity %q", route.Severity)
+		}
+		for _, name := range route.Notifiers {
+			if _, ok := r.notifiers[name]; !ok {
+				return nil, fmt.Errorf("route: unknown notifier %q", name)
+			}
+		}
+	}
+	return r, nil
+}
+
+// route 通知方式以及发送给它的事件：每条路由只选择级别不低于 route.Severity 的事件，
+// 同一通知方式被多条路由选择时取并集，保持事件原来的顺序；没有配置路由时所有通知方式收到全部事件
+func (r *notifyRouter) route(n Notification) map[string][]Event {
+	routed := make(map[string][]Event)
+	for name := range r.notifiers {
+		var events []Event
+		for _, e := range n.Events {
+			if r.accepts(name, n.ServiceName, e) {
+				events = append(events, e)
+			}
+		}
+		if len(events) > 0 {
+			routed[name] = events
+		}
+	}
+	return routed
+}
+
+// accepts 通知方式 name 是否接收服务 serviceName 的事件 e
+func (r *notifyRouter) accepts(name, serviceName string, e Event) bool {
+	if len(r.routes) == 0 {
+		return true
+	}
+	for _, route := range r.routes {
+		if severityLevels[e.Severity] < severityLevels[route.Severity] {
+			continue
+		}
+		if len(route.Services) > 0 && !contains(route.Services, serviceName) {
+			continue
+		}
+		if contains(route.Notifiers, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// loadNotifyRouter 读取通知配置，配置文件不存在或无效时不发送通知
+func loadNotifyRouter(mailAddressees brisk.MailAddressees) *notifyRouter {
+	var config brisk.NotifyConfigs
+	if err := brisk.LoadYamlFile(notifyConfigFile, &config); err != nil {
+		log.Printf("Notify-Error: load notify config error, notifications are disabled, err: %v \n", err)
+		return &notifyRouter{notifiers: make(map[string]Notifier), repeatInterval: defaultRepeatInterval}
+	}
+	router, err := newNotifyRouter(config, mailAddressees)
+	if err != nil {
+		log.Printf("Notify-Error: notify config is invalid, notifications are disabled, err: %v \n", err)
+		return &notifyRouter{notifiers: make(map[string]Notifier), repeatInterval: defaultRepeatInterval}
+	}
+	return router
+}
+
+// emit 记录事件，升级/修复结束时通过 flushEvents 一起发送；事件同时立即发送给事件流的订阅者
+func (s *Scheduler) emit(e Event) {
+	e.Message = strings.TrimSpace(e.Message)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.events.publish(e)
+	s.notifyMu.Lock()
+	s.pendingEvents[e.ServiceName] = append(s.pendingEvents[e.ServiceName], e)
+	s.notifyMu.Unlock()
+}
+
+// flushEvents 发送服务已记录的事件，并清空
+func (s *Scheduler) flushEvents(serviceName string, subject string) {
+	s.notifyMu.Lock()
+	events := s.pendingEvents[serviceName]
+	delete(s.pendingEvents, serviceName)
+	s.notifyMu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	s.sendNotification(newNotification(subject, serviceName, events))
+}
+
+// sendNotification 将通知发送给路由选择的通知方式，每个通知方式只收到路由给它的事件
+func (s *Scheduler) sendNotification(n Notification) {
+	routed := s.notifier.route(n)
+	if len(routed) == 0 {
+		log.Printf("Notify-Info: no notifier for %s, service: %s, severity: %s \n", n.Subject, n.ServiceName, n.Severity)
+		return
+	}
+	for name, events := range routed {
+		if err := s.notifier.notifiers[name].Notify(newNotification(n.Subject, n.ServiceName, events)); err != nil {
+			log.Printf("Notify-Error: notifier %s send %s error, err: %v \n", name, n.Subject, err)
+			continue
+		}
+		log.Printf("Notify-Info: notifier %s send %s successful, events: %d \n", name, n.Subject, len(events))
+	}
+}
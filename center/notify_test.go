@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyRoute(t *testing.T) {
+	config := brisk.NotifyConfigs{
+		Notifiers: []brisk.NotifierConfig{
+			{Name: "ops", Type: brisk.NotifierWebhook, URL: "http://ops.example.com/hook"},
+			{Name: "oncall", Type: brisk.NotifierSlack, URL: "http://slack.example.com/hook"},
+			{Name: "team", Type: brisk.NotifierDingTalk, URL: "http://dingtalk.example.com/hook"},
+		},
+		Routes: []brisk.NotifyRoute{
+			{Notifiers: []string{"ops"}},
+			{Severity: SeverityWarning, Notifiers: []string{"team"}},
+			{Severity: SeverityError, Notifiers: []string{"oncall"}},
+			{Services: []string{"hello"}, Notifiers: []string{"team"}},
+		},
+	}
+	router, err := newNotifyRouter(config, nil)
+	assert.NoError(t, err)
+
+	info := Event{Type: EventReplicaSucceeded, Severity: SeverityInfo}
+	warning := Event{Type: EventRollbackStarted, Severity: SeverityWarning}
+	failed := Event{Type: EventReplicaFailed, Severity: SeverityError}
+	n := newNotification("Rolling-Update-Info", "world", []Event{info, failed, warning})
+	assert.Equal(t, SeverityError, n.Severity)
+
+	routed := router.route(n)
+	assert.Equal(t, []Event{info, failed, warning}, routed["ops"])
+	assert.Equal(t, []Event{failed}, routed["oncall"])
+	assert.Equal(t, []Event{failed, warning}, routed["team"])
+
+	// hello 服务的所有事件都发送给 team
+	routed = router.route(newNotification("Rolling-Update-Info", "hello", []Event{info, warning}))
+	assert.Equal(t, []Event{info, warning}, routed["team"])
+	_, ok := routed["oncall"]
+	assert.False(t, ok)
+
+	_, err = newNotifyRouter(brisk.NotifyConfigs{Routes: []brisk.NotifyRoute{{Notifiers: []string{"ops"}}}}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"brisk"
+)
+
+// nodeLoad 节点当前的负载情况，供副本调度使用
+type nodeLoad struct {
+	Node brisk.NodeConfig
+	// Running 节点上已运行的其他服务容器数量
+	Running int
+	// Replicas 节点上已运行的此服务副本的 key（滚动升级时原地替换，不占用新的容量）
+	Replicas []string
+	// Score 满足 preferred 约束的得分
+	Score int
+	// assigned 已分配到此节点的副本数量
+	assigned int
+}
+
+// free 节点剩余可用容器数量，MaxContainers <= 0 表示不限制
+func (n nodeLoad) free() int {
+	if n.Node.MaxContainers <= 0 {
+		return int(^uint(0) >> 1)
+	}
+	return n.Node.MaxContainers - n.Running
+}
+
+// canTake 节点是否还能再放一个副本：可以原地替换已运行的副本，或者有剩余容量；
+// 固定端口的服务同一节点只能运行一个副本
+func (n nodeLoad) canTake(multiple bool) bool {
+	if !multiple && n.assigned > 0 {
+		return false
+	}
+	if n.assigned < len(n.Replicas) {
+		return true
+	}
+	return n.assigned+1-len(n.Replicas) <= n.free()
+}
+
+// replicaSlot 副本的位置：所在节点，在 keeper-"HostName"-image 中的 key，以及宿主机端口
+type replicaSlot struct {
+	Node brisk.NodeConfig
+	Key  string
+	// Port 动态端口的服务，替换已运行的副本时沿用原来的端口，新的副本为空，由 assignPorts 分配
+	Port string
+}
+
+// placeReplicas 为服务的每个副本选择服务器节点
+// 规则：只选择有keeper运行的节点；NeedNetPublic 的服务只能放在有公网的节点；
+// 不超过节点的 MaxContainers；满足服务 Placement 的 required 约束；同一服务的副本尽量分散在不同节点上
+func (s *Scheduler) placeReplicas(servConfig brisk.ServConfigs) ([]replicaSlot, error) {
+	keeperHost, err := getAllNolKeeper()
+	if err != nil {
+		return nil, err
+	}
+	nodeImages := getAllNodeImages(keeperHost, "Placement")
+	return placement(servConfig, s.serviceConfigs(), s.schedulableNodes(), nodeImages)
+}
+
+// placement 根据节点配置以及各节点上正在运行的镜像，计算每个副本的位置
+// nodeImages key 为节点 HostName，只有存在于 nodeImages 中的节点（keeper 正在运行）才参与调度
+// serviceMetas 用于检查节点上已运行的服务对此服务的 required 反亲和
+// 固定端口的服务同一节点只能运行一个副本；动态端口（Meta.Port 为 auto）的服务节点不足时可以在同一节点上运行多个副本
+func placement(servConfig brisk.ServConfigs, serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs, nodeImages map[string]brisk.NodeImages) ([]replicaSlot, error) {
+	if servConfig.Replica <= 0 {
+		msg := fmt.Sprintf("placement: service %s, replica must be greater than 0, replica: %d", servConfig.ServiceName, servConfig.Replica)
+		return nil, errors.New(msg)
+	}
+	multiple := servConfig.Meta.Port == brisk.PortAuto
+	var candidates []*nodeLoad
+	for _, node := range nodeMetas {
+		if servConfig.Meta.NeedNetPublic && !node.HasPublic {
+			continue
+		}
+		images, ok := nodeImages[node.HostName]
+		if !ok {
+			log.Printf("Placement-Info: node %s has no running keeper, skip \n", node.HostName)
+			continue
+		}
+		load := &nodeLoad{Node: node, Replicas: images.Replicas(servConfig.ServiceName)}
+		load.Running = len(images) - len(load.Replicas)
+		if !load.canTake(multiple) {
+			log.Printf("Placement-Info: node %s is full, running: %d, maxContainers: %d \n", node.HostName, load.Running, node.MaxContainers)
+			continue
+		}
+		if err := checkRequired(servConfig, serviceMetas, node, images); err != nil {
+			log.Printf("Placement-Info: node %s skipped for service %s, %v \n", node.HostName, servConfig.ServiceName, err)
+			continue
+		}
+		load.Score = preferredScore(servConfig.Placement, node, images)
+		candidates = append(candidates, load)
+	}
+	var slots []replicaSlot
+	for len(slots) < servConfig.Replica {
+		// 可以原地替换已运行副本的节点优先，其次已分配副本少的节点（分散），其次 preferred 得分高的节点，
+		// 其次剩余容量多的节点，最后按 HostName 保证结果稳定
+		var best *nodeLoad
+		for _, c := range candidates {
+			if !c.canTake(multiple) {
+				continue
+			}
+			if best == nil || betterNode(c, best) {
+				best = c
+			}
+		}
+		if best == nil {
+			msg := fmt.Sprintf("placement: service %s needs %d replicas, but only %d can be placed on %d eligible nodes (needNetPublic: %v, nodeSelector: %v, affinity: %v, antiAffinity: %v)",
+				servConfig.ServiceName, servConfig.Replica, len(slots), len(candidates), servConfig.Meta.NeedNetPublic,
+				servConfig.Placement.NodeSelector, servConfig.Placement.Affinity.Required, servConfig.Placement.AntiAffinity.Required)
+			return nil, errors.New(msg)
+		}
+		slot := replicaSlot{Node: best.Node}
+		if best.assigned < len(best.Replicas) {
+			slot.Key = best.Replicas[best.assigned]
+			if multiple {
+				slot.Port = nodeImages[best.Node.HostName][slot.Key].Env["Port"]
+			}
+		} else {
+			slot.Key = nextReplicaKey(servConfig.ServiceName, nodeImages[best.Node.HostName], slots, best.Node.HostName)
+		}
+		best.assigned++
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// betterNode 下一个副本放在节点 a 是否优于节点 b
+func betterNode(a, b *nodeLoad) bool {
+	aReplace, bReplace := a.assigned < len(a.Replicas), b.assigned < len(b.Replicas)
+	if aReplace != bReplace {
+		return aReplace
+	}
+	if a.assigned != b.assigned {
+		return a.assigned < b.assigned
+	}
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.free() != b.free() {
+		return a.free() > b.free()
+	}
+	return a.Node.HostName < b.Node.HostName
+}
+
+// nextReplicaKey 节点上还没有使用的最小序号的副本 key
+func nextReplicaKey(serviceName string, images brisk.NodeImages, slots []replicaSlot, hostName string) string {
+	for i := 0; ; i++ {
+		key := brisk.ReplicaKey(serviceName, i)
+		if _, ok := images[key]; ok {
+			continue
+		}
+		used := false
+		for _, slot := range slots {
+			if slot.Node.HostName == hostName && slot.Key == key {
+				used = true
+			}
+		}
+		if !used {
+			return key
+		}
+	}
+}
+
+// hasService 节点上是否运行着服务的副本
+func hasService(images brisk.NodeImages, serviceName string) bool {
+	return len(images.Replicas(serviceName)) > 0
+}
+
+// checkRequired 节点是否满足服务的 required 约束：节点标签匹配 NodeSelector；节点上运行着 Affinity.Required 的所有服务；
+// 节点上没有运行 AntiAffinity.Required 的服务，节点上运行的服务也没有将此服务列为 AntiAffinity.Required
+func checkRequired(servConfig brisk.ServConfigs, serviceMetas brisk.AllServConfigs, node brisk.NodeConfig, images brisk.NodeImages) error {
+	p := servConfig.Placement
+	if !matchLabels(p.NodeSelector, node.Labels) {
+		return fmt.Errorf("labels %v do not match nodeSelector %v", node.Labels, p.NodeSelector)
+	}
+	for _, name := range p.Affinity.Required {
+		if !hasService(images, name) {
+			return fmt.Errorf("required affinity service %s is not running", name)
+		}
+	}
+	for _, name := range p.AntiAffinity.Required {
+		if hasService(images, name) {
+			return fmt.Errorf("required antiaffinity service %s is running", name)
+		}
+	}
+	for key := range images {
+		name := brisk.ReplicaService(key)
+		if name == servConfig.ServiceName {
+			continue
+		}
+		for _, anti := range serviceMetas[name].Placement.AntiAffinity.Required {
+			if anti == servConfig.ServiceName {
+				return fmt.Errorf("running service %s has required antiaffinity to it", name)
+			}
+		}
+	}
+	return nil
+}
+
+// preferredScore 节点满足 preferred 约束的得分：每条匹配的 PreferredNodes 加 Weight（默认1），
+// 节点上每个 Affinity.Preferred 的服务加1，每个 AntiAffinity.Preferred 的服务减1
+func preferredScore(p brisk.Placement, node brisk.NodeConfig, images brisk.NodeImages) int {
+	score := 0
+	for _, preferred := range p.PreferredNodes {
+		if matchLabels(preferred.Labels, node.Labels) {
+			weight := preferred.Weight
+			if weight == 0 {
+				weight = 1
+			}
+			score += weight
+		}
+	}
+	for _, name := range p.Affinity.Preferred {
+		if hasService(images, name) {
+			score++
+		}
+	}
+	for _, name := range p.AntiAffinity.Preferred {
+		if hasService(images, name) {
+			score--
+		}
+	}
+	return score
+}
+
+// matchLabels 节点标签是否包含 selector 中的所有标签，selector 为空时匹配所有节点
+func matchLabels(selector, labels map[string]string) bool {
+	for key, value := range selector {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slotNames 副本位置的 "HostName/Key"，便于比较
+func slotNames(slots []replicaSlot) []string {
+	var names []string
+	for _, slot := range slots {
+		names = append(names, slot.Node.HostName+"/"+slot.Key)
+	}
+	return names
+}
+
+func TestPlacement(t *testing.T) {
+	nodes := brisk.NodeConfigs{
+		"node1": {HostName: "node1", HasPublic: true},
+		"node2": {HostName: "node2"},
+		"node3": {HostName: "node3", MaxContainers: 1},
+	}
+	tests := []struct {
+		name       string
+		servConfig brisk.ServConfigs
+		nodeImages map[string]brisk.NodeImages
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "spread over nodes",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 2},
+			nodeImages: map[string]brisk.NodeImages{"node1": {}, "node2": {}},
+			want:       []string{"node1/hello", "node2/hello"},
+		},
+		{
+			name:       "need public network",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 1, Meta: brisk.Meta{NeedNetPublic: true}},
+			nodeImages: map[string]brisk.NodeImages{"node1": {}, "node2": {}},
+			want:       []string{"node1/hello"},
+		},
+		{
+			name:       "replace running replica in place",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 1},
+			nodeImages: map[string]brisk.NodeImages{"node1": {}, "node2": {"hello": {}}},
+			want:       []string{"node2/hello"},
+		},
+		{
+			name:       "prefer node with more free containers",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 1},
+			nodeImages: map[string]brisk.NodeImages{"node2": {"a": {}, "b": {}}, "node3": {}},
+			want:       []string{"node2/hello"},
+		},
+		{
+			name:       "skip node without keeper",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 1},
+			nodeImages: map[string]brisk.NodeImages{"node3": {}},
+			want:       []string{"node3/hello"},
+		},
+		{
+			name:       "skip full node",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 1},
+			nodeImages: map[string]brisk.NodeImages{"node3": {"other": {}}},
+			wantErr:    true,
+		},
+		{
+			name:       "fixed port one replica per node",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 2},
+			nodeImages: map[string]brisk.NodeImages{"node1": {}},
+			wantErr:    true,
+		},
+		{
+			name:       "auto port multiple replicas per node",
+			servConfig: brisk.ServConfigs{ServiceName: "hello", Replica: 2, Meta: brisk.Meta{Port: brisk.PortAuto}},
+			nodeImages: map[string]brisk.NodeImages{"node1": {}},
+			want:       []string{"node1/hello", "node1/" + brisk.ReplicaKey("hello", 1)},
+		},
+		{
+			name:       "replica must be positive",
+			servConfig: brisk.ServConfigs{ServiceName: "hello"},
+			nodeImages: map[string]brisk.NodeImages{"node1": {}},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := placement(tt.servConfig, nil, nodes, tt.nodeImages)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, slotNames(got))
+		})
+	}
+}
+
+func TestPlacementKeepsAutoPort(t *testing.T) {
+	servConfig := brisk.ServConfigs{ServiceName: "hello", Replica: 2, Meta: brisk.Meta{Port: brisk.PortAuto}}
+	nodes := brisk.NodeConfigs{"node1": {HostName: "node1"}}
+	nodeImages := map[string]brisk.NodeImages{"node1": {"hello": {Env: map[string]string{"Port": "30001"}}}}
+
+	slots, err := placement(servConfig, nil, nodes, nodeImages)
+	assert.NoError(t, err)
+	// 替换已运行的副本沿用原来的端口，新的副本由 assignPorts 分配
+	assert.Equal(t, []replicaSlot{
+		{Node: nodes["node1"], Key: "hello", Port: "30001"},
+		{Node: nodes["node1"], Key: brisk.ReplicaKey("hello", 1)},
+	}, slots)
+}
+
+func TestBetterNode(t *testing.T) {
+	a := &nodeLoad{Node: brisk.NodeConfig{HostName: "a", MaxContainers: 10}}
+	b := &nodeLoad{Node: brisk.NodeConfig{HostName: "b", MaxContainers: 10}}
+	// 都相同时按 HostName 排序
+	assert.True(t, betterNode(a, b))
+	assert.False(t, betterNode(b, a))
+	// 剩余容量多的节点优先
+	a.Running = 5
+	assert.True(t, betterNode(b, a))
+	// preferred 得分高的节点优先
+	a.Score = 1
+	assert.True(t, betterNode(a, b))
+	// 分配的副本少的节点优先
+	a.assigned = 1
+	assert.True(t, betterNode(b, a))
+	// 可以原地替换已运行副本的节点优先
+	a.Replicas = []string{"hello", brisk.ReplicaKey("hello", 1)}
+	assert.True(t, betterNode(a, b))
+}
+
+func TestCheckRequired(t *testing.T) {
+	ssd := brisk.NodeConfig{HostName: "node1", Labels: map[string]string{"disk": "ssd", "zone": "a"}}
+	api := brisk.ServConfigs{ServiceName: "api", Placement: brisk.Placement{
+		NodeSelector: map[string]string{"disk": "ssd"},
+		Affinity:     brisk.ServiceAffinity{Required: []string{"redis"}},
+		AntiAffinity: brisk.ServiceAffinity{Required: []string{"batch"}},
+	}}
+	serviceMetas := brisk.AllServConfigs{
+		"db": {ServiceName: "db", Placement: brisk.Placement{AntiAffinity: brisk.ServiceAffinity{Required: []string{"api"}}}},
+	}
+
+	assert.NoError(t, checkRequired(api, serviceMetas, ssd, brisk.NodeImages{"redis": {}, "api": {}}))
+	// 节点标签不匹配
+	hdd := brisk.NodeConfig{HostName: "node2", Labels: map[string]string{"disk": "hdd"}}
+	assert.Error(t, checkRequired(api, serviceMetas, hdd, brisk.NodeImages{"redis": {}}))
+	// 缺少 required 亲和的服务
+	assert.Error(t, checkRequired(api, serviceMetas, ssd, brisk.NodeImages{}))
+	// 节点上运行着 required 反亲和的服务
+	assert.Error(t, checkRequired(api, serviceMetas, ssd, brisk.NodeImages{"redis": {}, "batch": {}}))
+	// 节点上运行的服务将 api 列为 required 反亲和
+	assert.Error(t, checkRequired(api, serviceMetas, ssd, brisk.NodeImages{"redis": {}, "db": {}}))
+}
+
+func TestPreferredScore(t *testing.T) {
+	p := brisk.Placement{
+		PreferredNodes: []brisk.WeightedLabels{
+			{Labels: map[string]string{"zone": "a"}, Weight: 3},
+			{Labels: map[string]string{"disk": "ssd"}},
+		},
+		Affinity:     brisk.ServiceAffinity{Preferred: []string{"cache"}},
+		AntiAffinity: brisk.ServiceAffinity{Preferred: []string{"batch", "cron"}},
+	}
+	node := brisk.NodeConfig{HostName: "node1", Labels: map[string]string{"zone": "a", "disk": "ssd"}}
+	assert.Equal(t, 4, preferredScore(p, node, brisk.NodeImages{}))
+	assert.Equal(t, 5, preferredScore(p, node, brisk.NodeImages{"cache": {}}))
+	assert.Equal(t, 3, preferredScore(p, node, brisk.NodeImages{"cache": {}, "batch": {}, "cron": {}}))
+	assert.Equal(t, 0, preferredScore(p, brisk.NodeConfig{HostName: "node2"}, brisk.NodeImages{}))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"brisk"
+
+	"github.com/labstack/echo"
+)
+
+// 计划中每个节点上副本的变化
+const (
+	PlanStart     = "start"     // 节点上没有此副本，启动新的副本
+	PlanReplace   = "replace"   // 节点上正在运行的副本被替换
+	PlanUntouched = "untouched" // 节点上正在运行的副本不在新的副本中，升级不会改变它，之后由周期性检查停止多余的副本
+)
+
+// deployPlan 升级计划：designImage 会生成的镜像，以及与各节点 keeper-"HostName"-image 记录的对比
+type deployPlan struct {
+	ServiceName string `json:"service_name"`
+	CommitHash  string `json:"commit_hash"`
+	// CurrentCommit 服务当前的版本
+	CurrentCommit string              `json:"current_commit"`
+	Images        []brisk.DockerImage `json:"images"`
+	Changes       []replicaChange     `json:"changes"`
+}
+
+// replicaChange 一个副本的变化
+type replicaChange struct {
+	Node string `json:"node"`
+	// Replica 副本在 keeper-"HostName"-image 中的 key
+	Replica string             `json:"replica"`
+	Action  string             `json:"action"`
+	From    *brisk.NodeImage   `json:"from,omitempty"`
+	To      *brisk.DockerImage `json:"to,omitempty"`
+	// Env 变化的环境变量
+	Env map[string]envChange `json:"env,omitempty"`
+	// SpecChanged 容器参数（资源限制，端口，挂载等）是否变化
+	SpecChanged bool `json:"spec_changed,omitempty"`
+	// Secrets 引用的密钥名称的变化（不包含密钥的值）
+	Secrets map[string]envChange `json:"secrets,omitempty"`
+}
+
+// envChange 环境变量的变化，为空表示没有此环境变量
+type envChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// initPlanAPI GET /api/plan/:service?commit=，只计算，不写入etcd
+func (s *Scheduler) initPlanAPI() {
+	s.HTTPServer.GET("/api/plan/:service", s.getDeployPlan)
+}
+
+// getDeployPlan commit 为空时使用服务当前的版本，即重新部署当前版本
+func (s *Scheduler) getDeployPlan(c echo.Context) error {
+	serviceName := c.Param("service")
+	servConfig, ok := s.serviceConfig(serviceName)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "service is not configured")
+	}
+	currentCommit := getServiceCommit(serviceName)
+	commitHash := c.QueryParam("commit")
+	if commitHash == "" {
+		commitHash = currentCommit
+	}
+	if commitHash == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "commit is required, current commitHash of the service is unknown")
+	}
+	keeperHost, err := getAllNolKeeper()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	nodeImages := getAllNodeImages(keeperHost, "Plan")
+	plan, err := planDeploy(servConfig, s.serviceConfigs(), s.schedulableNodes(), nodeImages, commitHash)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	plan.CurrentCommit = currentCommit
+	return c.JSON(http.StatusOK, plan)
+}
+
+// planDeploy 与 designImage 使用相同的调度，计算镜像以及各节点上副本的变化
+// 计划中的镜像没有 ID，实际升级时生成；动态端口的服务，新副本的端口在升级时分配，计划中 Port 为 auto
+func planDeploy(servConfig brisk.ServConfigs, serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs, nodeImages map[string]brisk.NodeImages, commitHash string) (deployPlan, error) {
+	plan := deployPlan{ServiceName: servConfig.ServiceName, CommitHash: commitHash, Images: []brisk.DockerImage{}, Changes: []replicaChange{}}
+	slots, err := placement(servConfig, serviceMetas, nodeMetas, nodeImages)
+	if err != nil {
+		return plan, err
+	}
+	fullName := fmt.Sprintf("%s:%s", servConfig.Meta.ImagePrefix, commitHash)
+	placed := make(map[string]bool)
+	for _, slot := range slots {
+		image := newDockerImage(servConfig, slot, fullName, time.Now())
+		image.ID = ""
+		plan.Images = append(plan.Images, image)
+		placed[slot.Node.HostName+"/"+slot.Key] = true
+		change := replicaChange{Node: slot.Node.HostName, Replica: slot.Key, Action: PlanStart, To: &image}
+		if current, ok := nodeImages[slot.Node.HostName][slot.Key]; ok {
+			change.Action = PlanReplace
+			change.From = &current
+			change.Env = diffEnv(current.Env, image.Env)
+			change.SpecChanged = !reflect.DeepEqual(current.Spec, image.Spec)
+			change.Secrets = diffEnv(current.Secrets, image.Secrets)
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+	for host, images := range nodeImages {
+		for _, key := range images.Replicas(servConfig.ServiceName) {
+			if placed[host+"/"+key] {
+				continue
+			}
+			current := images[key]
+			plan.Changes = append(plan.Changes, replicaChange{Node: host, Replica: key, Action: PlanUntouched, From: &current})
+		}
+	}
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		if plan.Changes[i].Node != plan.Changes[j].Node {
+			return plan.Changes[i].Node < plan.Changes[j].Node
+		}
+		return plan.Changes[i].Replica < plan.Changes[j].Replica
+	})
+	return plan, nil
+}
+
+// diffEnv 两组环境变量中不同的部分
+func diffEnv(from, to map[string]string) map[string]envChange {
+	diff := make(map[string]envChange)
+	for key, value := range from {
+		if to[key] != value {
+			diff[key] = envChange{From: value, To: to[key]}
+		}
+	}
+	for key, value := range to {
+		if _, ok := from[key]; !ok {
+			diff[key] = envChange{To: value}
+		}
+	}
+	return diff
+}
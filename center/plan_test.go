@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanDeploy(t *testing.T) {
+	servConfig := brisk.ServConfigs{ServiceName: "hello", Replica: 2, Meta: brisk.Meta{ImagePrefix: "eglass/hello", Port: "8080"}}
+	nodes := brisk.NodeConfigs{
+		"node1": {HostName: "node1", PrivateIP: "10.0.0.1"},
+		"node2": {HostName: "node2", PrivateIP: "10.0.0.2"},
+	}
+	running := brisk.NodeImage{Node: "node1", FullName: "eglass/hello:v1", Env: map[string]string{"Port": "8000", "Debug": "1"}}
+	nodeImages := map[string]brisk.NodeImages{"node1": {"hello": running}, "node2": {}}
+
+	plan, err := planDeploy(servConfig, nil, nodes, nodeImages, "v2")
+	assert.NoError(t, err)
+	assert.Len(t, plan.Images, 2)
+	for _, image := range plan.Images {
+		assert.Empty(t, image.ID)
+		assert.Equal(t, "eglass/hello:v2", image.FullName)
+	}
+	if assert.Len(t, plan.Changes, 2) {
+		replace, start := plan.Changes[0], plan.Changes[1]
+		assert.Equal(t, PlanReplace, replace.Action)
+		assert.Equal(t, &running, replace.From)
+		assert.Equal(t, envChange{From: "8000", To: "8080"}, replace.Env["Port"])
+		assert.Equal(t, envChange{From: "1"}, replace.Env["Debug"])
+		assert.Equal(t, envChange{To: "10.0.0.1"}, replace.Env["IP"])
+		assert.Equal(t, "node2", start.Node)
+		assert.Equal(t, PlanStart, start.Action)
+		assert.Nil(t, start.From)
+	}
+
+	// 副本减少时，不在新副本中的节点保持不变
+	servConfig.Replica = 1
+	nodeImages["node2"] = brisk.NodeImages{"hello": {Node: "node2"}}
+	plan, err = planDeploy(servConfig, nil, nodes, nodeImages, "v2")
+	assert.NoError(t, err)
+	var actions []string
+	for _, change := range plan.Changes {
+		actions = append(actions, change.Action)
+	}
+	assert.ElementsMatch(t, []string{PlanReplace, PlanUntouched}, actions)
+}
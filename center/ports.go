@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// portAllocationPrefix 动态分配的宿主机端口 center-port-"HostName"-"Port"，值为 PortAllocation
+const portAllocationPrefix = "center-port-"
+
+// portReleaseDelay 分配的端口上没有对应的副本运行超过此时间后释放（分配之后副本可能还在启动）
+const portReleaseDelay = 30 * time.Minute
+
+// PortAllocation 分配给服务副本的宿主机端口
+type PortAllocation struct {
+	HostName     string    `json:"host_name"`
+	Port         string    `json:"port"`
+	ServiceName  string    `json:"service_name"`
+	Replica      string    `json:"replica"`
+	AllocateTime time.Time `json:"allocate_time"`
+}
+
+func portKey(hostName, port string) string {
+	return portAllocationPrefix + hostName + "-" + port
+}
+
+// getPortAllocations 节点上已分配的端口，hostName 为空时返回所有节点的
+func getPortAllocations(hostName string) ([]PortAllocation, error) {
+	prefix := portAllocationPrefix
+	if hostName != "" {
+		prefix = portAllocationPrefix + hostName + "-"
+	}
+	resp, err := cli.Get(context.Background(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	var allocations []PortAllocation
+	for _, kv := range resp.Kvs {
+		var allocation PortAllocation
+		if err := json.Unmarshal(kv.Value, &allocation); err != nil {
+			log.Printf("Port-Error: %s format error, err: %v \n", string(kv.Key), err)
+			continue
+		}
+		// 前缀可能匹配到以此节点名开头的其他节点
+		if hostName != "" && allocation.HostName != hostName {
+			continue
+		}
+		allocations = append(allocations, allocation)
+	}
+	return allocations, nil
+}
+
+// getPortAllocation 节点上端口的分配记录，没有分配时返回 nil
+func getPortAllocation(hostName, port string) (*PortAllocation, error) {
+	resp, err := cli.Get(context.Background(), portKey(hostName, port))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	allocation := &PortAllocation{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, allocation); err != nil {
+		return nil, err
+	}
+	return allocation, nil
+}
+
+// reservePort 端口没有被分配时写入分配记录，返回是否写入成功
+func reservePort(allocation PortAllocation) (bool, error) {
+	value, err := json.Marshal(allocation)
+	if err != nil {
+		return false, err
+	}
+	key := portKey(allocation.HostName, allocation.Port)
+	resp, err := cli.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// assignPorts 为动态端口（Meta.Port 为 auto）服务的新副本分配宿主机端口，写入 slot.Port
+// 替换已运行的副本时沿用原来的端口，分配记录不存在时（升级之前启动的副本）补上；端口已分配给其他副本时重新分配
+func assignPorts(servConfig brisk.ServConfigs, slots []replicaSlot) error {
+	if servConfig.Meta.Port != brisk.PortAuto {
+		return nil
+	}
+	for i := range slots {
+		allocation := PortAllocation{
+			HostName:     slots[i].Node.HostName,
+			Port:         slots[i].Port,
+			ServiceName:  servConfig.ServiceName,
+			Replica:      slots[i].Key,
+			AllocateTime: time.Now(),
+		}
+		if allocation.Port != "" {
+			owned, err := ownPort(allocation)
+			if err != nil {
+				return err
+			}
+			if owned {
+				continue
+			}
+			log.Printf("Port-Info: node: %s, port: %s is allocated to another replica, allocate a new port for service: %s, replica: %s \n",
+				allocation.HostName, allocation.Port, allocation.ServiceName, allocation.Replica)
+		}
+		port, err := allocatePort(slots[i].Node, allocation)
+		if err != nil {
+			return err
+		}
+		slots[i].Port = port
+	}
+	return nil
+}
+
+// ownPort 为副本保留原来的端口：没有分配记录时写入，已有记录时需要是同一服务的同一副本
+func ownPort(allocation PortAllocation) (bool, error) {
+	ok, err := reservePort(allocation)
+	if err != nil || ok {
+		return ok, err
+	}
+	existing, err := getPortAllocation(allocation.HostName, allocation.Port)
+	if err != nil {
+		return false, err
+	}
+	return existing != nil && existing.ServiceName == allocation.ServiceName && existing.Replica == allocation.Replica, nil
+}
+
+// allocatePort 从节点的 PortRange 中选出最小的空闲端口：跳过已分配的端口，以及节点上运行的容器已使用的端口
+func allocatePort(node brisk.NodeConfig, allocation PortAllocation) (string, error) {
+	low, high, err := node.Ports()
+	if err != nil {
+		return "", err
+	}
+	used := make(map[string]bool)
+	allocations, err := getPortAllocations(node.HostName)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range allocations {
+		used[a.Port] = true
+	}
+	images, err := getNodeImages(node.HostName)
+	if err != nil {
+		return "", err
+	}
+	for _, image := range images {
+		used[image.Env["Port"]] = true
+		for _, port := range image.Spec.Ports {
+			used[port.HostPort] = true
+		}
+	}
+	for p := low; p <= high; p++ {
+		port := strconv.Itoa(p)
+		if used[port] {
+			continue
+		}
+		allocation.Port = port
+		ok, err := reservePort(allocation)
+		if err != nil {
+			return "", err
+		}
+		// 其他 center 同时分配了此端口，继续找下一个
+		if !ok {
+			continue
+		}
+		log.Printf("Port-Allocate: node: %s, port: %s, service: %s, replica: %s \n", node.HostName, port, allocation.ServiceName, allocation.Replica)
+		return port, nil
+	}
+	return "", fmt.Errorf("node %s: no free port in %d-%d", node.HostName, low, high)
+}
+
+// releasePorts 释放没有副本使用的端口：节点上 keeper 记录的副本端口不一致或者副本不存在，且分配超过 portReleaseDelay
+// keeper 没有运行的节点不释放，正在升级/修复的服务不释放
+func (s *Scheduler) releasePorts(nodeImages map[string]brisk.NodeImages) {
+	allocations, err := getPortAllocations("")
+	if err != nil {
+		log.Printf("Port-Error: get port allocations error, err: %v \n", err)
+		return
+	}
+	for _, allocation := range allocations {
+		images, ok := nodeImages[allocation.HostName]
+		if !ok {
+			continue
+		}
+		if image, ok := images[allocation.Replica]; ok && image.Env["Port"] == allocation.Port {
+			continue
+		}
+		if time.Since(allocation.AllocateTime) < portReleaseDelay {
+			continue
+		}
+		s.mu.Lock()
+		rolling := s.RollingServices[allocation.ServiceName]
+		s.mu.Unlock()
+		if rolling {
+			continue
+		}
+		if _, err := cli.Delete(context.Background(), portKey(allocation.HostName, allocation.Port)); err != nil {
+			log.Printf("Port-Error: release port error, node: %s, port: %s, err: %v \n", allocation.HostName, allocation.Port, err)
+			continue
+		}
+		log.Printf("Port-Release: node: %s, port: %s, service: %s, replica: %s \n", allocation.HostName, allocation.Port, allocation.ServiceName, allocation.Replica)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo"
+)
+
+// serviceQueue 服务是否正在升级，以及等待升级的镜像事件
+type serviceQueue struct {
+	ServiceName string              `json:"service_name"`
+	Rolling     bool                `json:"rolling"`
+	Queue       []ServiceImageEvent `json:"queue"`
+}
+
+// initQueueAPI 查看等待升级的镜像事件队列，队列只保存在leader中
+func (s *Scheduler) initQueueAPI() {
+	g := s.HTTPServer.Group("/api/queue", s.leaderOnly)
+	g.GET("", s.listQueues)
+	g.GET("/:name", s.getQueue)
+}
+
+// serviceQueue 取得服务的队列副本
+func (s *Scheduler) serviceQueue(serviceName string) serviceQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := append([]ServiceImageEvent{}, s.RollingServQueue[serviceName]...)
+	return serviceQueue{ServiceName: serviceName, Rolling: s.RollingServices[serviceName], Queue: queue}
+}
+
+// busyServices 正在升级或者有等待事件的服务，按服务名排序
+func (s *Scheduler) busyServices() []string {
+	s.mu.Lock()
+	var names []string
+	for name := range s.serviceConfigs() {
+		if s.RollingServices[name] || len(s.RollingServQueue[name]) > 0 {
+			names = append(names, name)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+func (s *Scheduler) listQueues(c echo.Context) error {
+	queues := []serviceQueue{}
+	for _, name := range s.busyServices() {
+		queues = append(queues, s.serviceQueue(name))
+	}
+	return c.JSON(http.StatusOK, queues)
+}
+
+func (s *Scheduler) getQueue(c echo.Context) error {
+	name := c.Param("name")
+	if _, ok := s.serviceConfig(name); !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "service is not configured")
+	}
+	return c.JSON(http.StatusOK, s.serviceQueue(name))
+}
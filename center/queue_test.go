@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesceQueue(t *testing.T) {
+	now := time.Now()
+	event := func(commitHash string, age time.Duration) ServiceImageEvent {
+		return ServiceImageEvent{ServiceName: "hello", CommitHash: commitHash, CreateTime: now.Add(-age)}
+	}
+
+	var queue []ServiceImageEvent
+	queue = coalesceQueue(queue, event("a", 3*time.Minute))
+	assert.Equal(t, []ServiceImageEvent{event("a", 3*time.Minute)}, queue)
+
+	// 新版本取代队列中的旧版本
+	queue = coalesceQueue(queue, event("c", time.Minute))
+	assert.Equal(t, []ServiceImageEvent{event("c", time.Minute)}, queue)
+
+	// 延迟到达的旧版本被丢弃，按 CreateTime 而不是到达顺序
+	queue = coalesceQueue(queue, event("b", 2*time.Minute))
+	assert.Equal(t, []ServiceImageEvent{event("c", time.Minute)}, queue)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"brisk"
+)
+
+const (
+	// repairInterval 同一服务两次修复之间的最小间隔，避免副本反复启动失败时不停重试
+	repairInterval = 10 * time.Minute
+	// maxRepairsPerCheck 每次检查最多修复的服务数量
+	maxRepairsPerCheck = 3
+	// orphanGracePeriod 服务不在服务列表中持续这么久（多次检查）之后才停止，避免配置短暂缺失时误停服务
+	orphanGracePeriod = 10 * time.Minute
+	// maxOrphanServices 同时不在服务列表中的服务超过此数量时，可能是配置错误，只记录日志不停止
+	maxOrphanServices = 1
+)
+
+// repairPlan 一个服务的修复计划：在节点上启动缺少的副本，停止多余的副本
+type repairPlan struct {
+	ServiceName string
+	Expect      int
+	Actual      int
+	// Start 需要启动的副本
+	Start []replicaSlot
+	// Stop 需要停止的副本
+	Stop []runningReplica
+	// Reason 停止副本的原因
+	Reason string
+}
+
+// runningReplica 节点上正在运行的副本
+type runningReplica struct {
+	Node  string
+	Key   string
+	Image brisk.NodeImage
+	// load 节点上运行的容器数量
+	load int
+}
+
+// reconcile 对比每个服务期望的副本数量与实际运行的副本，修复副本数量不正确的服务，停止不在服务列表中的服务
+// 正在升级的服务，以及 repairInterval 内修复过的服务跳过，每次最多修复 maxRepairsPerCheck 个服务
+func (s *Scheduler) reconcile(nodeImages map[string]brisk.NodeImages, failServName []string) {
+	serviceMetas := s.serviceConfigs()
+	nodeMetas := s.schedulableNodes()
+	var plans []repairPlan
+	for _, name := range failServName {
+		plan, err := planRepair(serviceMetas[name], serviceMetas, nodeMetas, nodeImages)
+		if err != nil {
+			log.Printf("Reconcile-Error: service: %s, can not repair, err: %v \n", name, err)
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	orphans := planOrphans(serviceMetas, nodeImages)
+	s.mu.Lock()
+	orphans = confirmOrphans(orphans, s.orphanSince, time.Now())
+	s.mu.Unlock()
+	plans = append(plans, orphans...)
+	if len(plans) == 0 {
+		return
+	}
+	s.mu.Lock()
+	if s.reconciling {
+		s.mu.Unlock()
+		log.Println("Reconcile-Info: last reconcile is still running, skip")
+		return
+	}
+	var repairs []repairPlan
+	for _, plan := range plans {
+		if len(repairs) >= maxRepairsPerCheck {
+			log.Printf("Reconcile-Info: service: %s, reach max repairs %d per check, repair next time \n", plan.ServiceName, maxRepairsPerCheck)
+			continue
+		}
+		if s.RollingServices[plan.ServiceName] {
+			log.Printf("Reconcile-Info: service: %s is rolling, skip \n", plan.ServiceName)
+			continue
+		}
+		if last, ok := s.lastRepair[plan.ServiceName]; ok && time.Since(last) < repairInterval {
+			log.Printf("Reconcile-Info: service: %s was repaired at %v, skip \n", plan.ServiceName, last.Format("2006-01-02 15:04:05"))
+			continue
+		}
+		// 修复期间与滚动升级互斥，新的镜像事件进入队列
+		s.RollingServices[plan.ServiceName] = true
+		s.lastRepair[plan.ServiceName] = time.Now()
+		repairs = append(repairs, plan)
+	}
+	if len(repairs) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.reconciling = true
+	s.mu.Unlock()
+	go func() {
+		for _, plan := range repairs {
+			s.repair(plan)
+			s.releaseService(plan.ServiceName)
+		}
+		s.mu.Lock()
+		s.reconciling = false
+		s.mu.Unlock()
+	}()
+}
+
+// planRepair 计算服务的修复计划
+// 副本不足时，通过 placement 选出副本的位置，其中还没有运行的副本启动新的副本；
+// 副本过多时，优先停止不可调度（停止调度或不在节点配置中）的节点上的副本，其次负载最高的节点上的副本
+func planRepair(servConfig brisk.ServConfigs, serviceMetas brisk.AllServConfigs, nodeMetas brisk.NodeConfigs, nodeImages map[string]brisk.NodeImages) (repairPlan, error) {
+	plan := repairPlan{ServiceName: servConfig.ServiceName, Expect: servConfig.Replica}
+	var running []runningReplica
+	for host, images := range nodeImages {
+		for _, key := range images.Replicas(servConfig.ServiceName) {
+			running = append(running, runningReplica{Node: host, Key: key, Image: images[key], load: len(images)})
+		}
+	}
+	plan.Actual = len(running)
+	if plan.Actual < plan.Expect {
+		slots, err := placement(servConfig, serviceMetas, nodeMetas, nodeImages)
+		if err != nil {
+			return plan, err
+		}
+		for _, slot := range slots {
+			if _, ok := nodeImages[slot.Node.HostName][slot.Key]; !ok {
+				plan.Start = append(plan.Start, slot)
+			}
+		}
+		return plan, nil
+	}
+	sort.Slice(running, func(i, j int) bool {
+		_, iSchedulable := nodeMetas[running[i].Node]
+		_, jSchedulable := nodeMetas[running[j].Node]
+		if iSchedulable != jSchedulable {
+			return !iSchedulable
+		}
+		if running[i].load != running[j].load {
+			return running[i].load > running[j].load
+		}
+		if running[i].Node != running[j].Node {
+			return running[i].Node < running[j].Node
+		}
+		return running[i].Key > running[j].Key
+	})
+	plan.Stop = append(plan.Stop, running[:plan.Actual-plan.Expect]...)
+	plan.Reason = fmt.Sprintf("too many replicas, expect: %d, actual: %d", plan.Expect, plan.Actual)
+	return plan, nil
+}
+
+// planOrphans 节点上运行着但已不在服务列表中的服务，全部停止
+func planOrphans(serviceMetas brisk.AllServConfigs, nodeImages map[string]brisk.NodeImages) []repairPlan {
+	orphans := make(map[string]*repairPlan)
+	for host, images := range nodeImages {
+		for key, nodeImage := range images {
+			name := brisk.ReplicaService(key)
+			if _, ok := serviceMetas[name]; ok {
+				continue
+			}
+			plan, ok := orphans[name]
+			if !ok {
+				plan = &repairPlan{ServiceName: name, Reason: "service is not configured"}
+				orphans[name] = plan
+			}
+			plan.Actual++
+			plan.Stop = append(plan.Stop, runningReplica{Node: host, Key: key, Image: nodeImage})
+		}
+	}
+	var names []string
+	for name := range orphans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var plans []repairPlan
+	for _, name := range names {
+		plans = append(plans, *orphans[name])
+	}
+	return plans
+}
+
+// confirmOrphans 过滤出可以停止的不在服务列表中的服务
+// orphanSince 记录每个服务第一次被发现不在服务列表中的时间，已恢复的服务删除记录；
+// 持续 orphanGracePeriod 之后才停止，同时超过 maxOrphanServices 个服务时全部跳过
+func confirmOrphans(orphans []repairPlan, orphanSince map[string]time.Time, now time.Time) []repairPlan {
+	current := make(map[string]bool)
+	for _, plan := range orphans {
+		current[plan.ServiceName] = true
+		if _, ok := orphanSince[plan.ServiceName]; !ok {
+			orphanSince[plan.ServiceName] = now
+		}
+	}
+	for name := range orphanSince {
+		if !current[name] {
+			delete(orphanSince, name)
+		}
+	}
+	if len(orphans) > maxOrphanServices {
+		var names []string
+		for _, plan := range orphans {
+			names = append(names, plan.ServiceName)
+		}
+		log.Printf("Reconcile-Error: %d services are not configured at the same time, maybe a config error, skip stopping: %v \n", len(orphans), names)
+		return nil
+	}
+	var confirmed []repairPlan
+	for _, plan := range orphans {
+		since := orphanSince[plan.ServiceName]
+		if now.Sub(since) < orphanGracePeriod {
+			log.Printf("Reconcile-Info: service: %s is not configured since %v, stop after %v \n", plan.ServiceName, since.Format("2006-01-02 15:04:05"), orphanGracePeriod)
+			continue
+		}
+		confirmed = append(confirmed, plan)
+	}
+	return confirmed
+}
+
+// repair 执行修复计划，每个修复动作都记录为通知事件，结束后一起发送
+// 新的副本使用服务当前的版本，通过 docker-image-* / rolling-update-* 的下发与反馈流程启动
+func (s *Scheduler) repair(plan repairPlan) {
+	serviceName := plan.ServiceName
+	msg := fmt.Sprintf("Reconcile-Info: service: %s, wrong number of service replicas, expect: %d, actual: %d, start: %d, stop: %d \n",
+		serviceName, plan.Expect, plan.Actual, len(plan.Start), len(plan.Stop))
+	log.Print(msg)
+	s.emit(Event{Type: EventReconcileStarted, Severity: SeverityWarning, ServiceName: serviceName, Message: msg})
+	if len(plan.Start) > 0 {
+		images, err := s.startReplicas(plan)
+		event := Event{Type: EventReconcileSucceeded, Severity: SeverityInfo, ServiceName: serviceName}
+		if err != nil {
+			msg = fmt.Sprintf("Reconcile-Start-Fail: service: %s, err: %v \n", serviceName, err)
+			event.Type, event.Severity = EventReconcileFailed, SeverityError
+		} else {
+			msg = fmt.Sprintf("Reconcile-Start-Successful: service: %s, %d replicas started \n", serviceName, len(plan.Start))
+		}
+		log.Print(msg)
+		event.Message = msg
+		s.emit(event)
+		for _, d := range images {
+			cli.Delete(context.Background(), brisk.RollingFeedbackPrefix+d.ID)
+		}
+	}
+	for _, replica := range plan.Stop {
+		containerID := replica.Image.ContainerID
+		stop := brisk.StopImage{ServiceName: serviceName, ContainerID: containerID, Reason: plan.Reason, Replica: replica.Key}
+		event := Event{Type: EventReplicaStopped, Severity: SeverityWarning, ServiceName: serviceName, Node: replica.Node}
+		if err := putStopImage(replica.Node, stop); err != nil {
+			msg = fmt.Sprintf("Reconcile-Stop-Fail: service: %s, node: %s, replica: %s, containerID: %s, err: %v \n", serviceName, replica.Node, replica.Key, containerID, err)
+			event.Type, event.Severity = EventReconcileFailed, SeverityError
+		} else {
+			msg = fmt.Sprintf("Reconcile-Stop: service: %s, node: %s, replica: %s, containerID: %s, reason: %s \n", serviceName, replica.Node, replica.Key, containerID, plan.Reason)
+		}
+		log.Print(msg)
+		event.Message = msg
+		s.emit(event)
+	}
+	subject := fmt.Sprintf("%s,service-name: %s", "Reconcile-Info", serviceName)
+	s.flushEvents(serviceName, subject)
+}
+
+// startReplicas 在修复计划的节点上启动服务当前版本的副本，返回下发的镜像；动态端口的服务先分配端口
+func (s *Scheduler) startReplicas(plan repairPlan) ([]brisk.DockerImage, error) {
+	servConfig, ok := s.serviceConfig(plan.ServiceName)
+	if !ok {
+		return nil, errors.New("service is not configured")
+	}
+	commitHash := getServiceCommit(plan.ServiceName)
+	if commitHash == "" {
+		return nil, errors.New("current commitHash of the service is unknown")
+	}
+	if err := checkSecrets(servConfig); err != nil {
+		return nil, err
+	}
+	if err := assignPorts(servConfig, plan.Start); err != nil {
+		return nil, err
+	}
+	fullName := fmt.Sprintf("%s:%s", servConfig.Meta.ImagePrefix, commitHash)
+	var images []brisk.DockerImage
+	for _, slot := range plan.Start {
+		images = append(images, newDockerImage(servConfig, slot, fullName, time.Now()))
+	}
+	_, err := s.rollImages(plan.ServiceName, commitHash, images, 0, batchSize(servConfig.Strategy), rollHooks{})
+	return images, err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replicaNames 要停止的副本的 "Node/Key"，便于比较
+func replicaNames(replicas []runningReplica) []string {
+	var names []string
+	for _, r := range replicas {
+		names = append(names, r.Node+"/"+r.Key)
+	}
+	return names
+}
+
+func TestPlanRepair(t *testing.T) {
+	nodes := brisk.NodeConfigs{
+		"node1": {HostName: "node1"},
+		"node2": {HostName: "node2"},
+	}
+	hello := func(replica int) brisk.ServConfigs {
+		return brisk.ServConfigs{ServiceName: "hello", Replica: replica}
+	}
+
+	t.Run("start missing replica", func(t *testing.T) {
+		nodeImages := map[string]brisk.NodeImages{"node1": {"hello": {Node: "node1"}}, "node2": {}}
+		plan, err := planRepair(hello(2), nil, nodes, nodeImages)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, plan.Actual)
+		assert.Equal(t, []string{"node2/hello"}, slotNames(plan.Start))
+		assert.Empty(t, plan.Stop)
+	})
+
+	t.Run("stop replica on busiest node", func(t *testing.T) {
+		nodeImages := map[string]brisk.NodeImages{
+			"node1": {"hello": {Node: "node1"}},
+			"node2": {"hello": {Node: "node2"}, "other": {Node: "node2"}},
+		}
+		plan, err := planRepair(hello(1), nil, nodes, nodeImages)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, plan.Actual)
+		assert.Empty(t, plan.Start)
+		assert.Equal(t, []string{"node2/hello"}, replicaNames(plan.Stop))
+		assert.NotEmpty(t, plan.Reason)
+	})
+
+	t.Run("stop extra replica on the same node", func(t *testing.T) {
+		second := brisk.ReplicaKey("hello", 1)
+		nodeImages := map[string]brisk.NodeImages{"node1": {"hello": {Node: "node1"}, second: {Node: "node1"}}}
+		plan, err := planRepair(hello(1), nil, nodes, nodeImages)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, plan.Actual)
+		assert.Equal(t, []string{"node1/" + second}, replicaNames(plan.Stop))
+	})
+
+	t.Run("not enough nodes", func(t *testing.T) {
+		_, err := planRepair(hello(3), nil, nodes, map[string]brisk.NodeImages{"node1": {}, "node2": {}})
+		assert.Error(t, err)
+	})
+}
+
+func TestPlanOrphans(t *testing.T) {
+	serviceMetas := brisk.AllServConfigs{"hello": {ServiceName: "hello", Replica: 1}}
+	nodeImages := map[string]brisk.NodeImages{
+		"node1": {"hello": {Node: "node1"}, "old": {Node: "node1"}},
+		"node2": {brisk.ReplicaKey("old", 1): {Node: "node2"}, "gone": {Node: "node2"}},
+	}
+	plans := planOrphans(serviceMetas, nodeImages)
+	assert.Len(t, plans, 2)
+	assert.Equal(t, "gone", plans[0].ServiceName)
+	assert.Equal(t, []string{"node2/gone"}, replicaNames(plans[0].Stop))
+	assert.Equal(t, "old", plans[1].ServiceName)
+	assert.Equal(t, 2, plans[1].Actual)
+	assert.ElementsMatch(t, []string{"node1/old", "node2/" + brisk.ReplicaKey("old", 1)}, replicaNames(plans[1].Stop))
+}
+
+// TestConfirmOrphans 模拟多次周期性检查
+func TestConfirmOrphans(t *testing.T) {
+	old := repairPlan{ServiceName: "old"}
+	gone := repairPlan{ServiceName: "gone"}
+	orphanSince := make(map[string]time.Time)
+	start := time.Now()
+
+	// 第一次发现，等待 orphanGracePeriod
+	assert.Empty(t, confirmOrphans([]repairPlan{old}, orphanSince, start))
+	assert.Equal(t, start, orphanSince["old"])
+	assert.Empty(t, confirmOrphans([]repairPlan{old}, orphanSince, start.Add(orphanGracePeriod/2)))
+
+	// 持续超过 orphanGracePeriod 之后停止
+	assert.Equal(t, []repairPlan{old}, confirmOrphans([]repairPlan{old}, orphanSince, start.Add(orphanGracePeriod)))
+
+	// 同时有多个服务不在服务列表中，可能是配置错误，都不停止
+	assert.Empty(t, confirmOrphans([]repairPlan{gone, old}, orphanSince, start.Add(2*orphanGracePeriod)))
+	assert.Len(t, orphanSince, 2)
+
+	// 服务重新加入服务列表，删除记录
+	assert.Empty(t, confirmOrphans(nil, orphanSince, start.Add(3*orphanGracePeriod)))
+	assert.Empty(t, orphanSince)
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/rs/xid"
+)
+
+// feedbackTimeout 每批副本等待keeper反馈的最长时间
+const feedbackTimeout = 2 * time.Minute
+
+// runRollout 按照升级状态执行滚动升级：设计镜像 -> 逐个下发 -> 失败时回滚
+// 状态每次变化都同步到etcd，center 重启后从中断处继续
+func (s *Scheduler) runRollout(state *RolloutState) {
+	defer s.completeRollout(state)
+	control := s.registerControl(state)
+	serviceName := state.ServiceName
+	commitHash := state.CommitHash
+	log.Printf("Info: rolling-update start; service info : serviceName: [%s], commitHash: %s, createTime: %v, status: %s \n", serviceName, commitHash, state.CreateTime, state.Status)
+	if state.Status == RolloutPending {
+		// 升级前的版本号，升级失败时回滚使用
+		state.PreviousCommit = getServiceCommit(serviceName)
+		state.Running = runningReplicas(serviceName)
+		// design -- images
+		dockerImages, err := s.designImage(serviceName, commitHash, state.CreateTime)
+		log.Printf("Info: rolling-update : dockerImages : %v \n", dockerImages)
+		if err != nil {
+			msg := fmt.Sprintf("Rolling-Error : design image error, service: %s, commitHash: %s, err: %v \n", serviceName, commitHash, err)
+			log.Print(msg)
+			s.emit(Event{Type: EventRolloutFailed, Severity: SeverityError, ServiceName: serviceName, CommitHash: commitHash, Message: msg})
+			state.fail(OutcomeFailed, msg)
+			return
+		}
+		state.Images = dockerImages
+		state.Status = RolloutRolling
+		state.save()
+		msg := fmt.Sprintf("Rolling-Start: service: %s, commitHash: %s, source: %s, replicas: %d \n", serviceName, commitHash, state.Source, len(dockerImages))
+		log.Print(msg)
+		s.emit(Event{Type: EventRolloutStarted, Severity: SeverityInfo, ServiceName: serviceName, CommitHash: commitHash, Message: msg})
+	}
+	if state.Status == RolloutRolling {
+		dispatched, err := s.rollNewImages(state, control)
+		if err == nil {
+			putServiceCommit(serviceName, commitHash)
+			msg := fmt.Sprintf("Rolling-AllServ-Successful: service: %s, all service replicas run successfully \n", serviceName)
+			s.emit(Event{Type: EventRolloutSucceeded, Severity: SeverityInfo, ServiceName: serviceName, CommitHash: commitHash, Message: msg})
+			log.Print(msg)
+			state.Outcome = OutcomeSuccess
+			return
+		}
+		if err == errRolloutCancelled {
+			_, rollback := control.cancelRequested()
+			msg := fmt.Sprintf("Rolling-Cancelled: service: %s, commitHash: %s, rollout cancelled after %d/%d replicas, rollback: %v \n",
+				serviceName, commitHash, dispatched, len(state.Images), rollback)
+			log.Print(msg)
+			s.emit(Event{Type: EventRolloutCancelled, Severity: SeverityWarning, ServiceName: serviceName, CommitHash: commitHash, Message: msg})
+			state.fail(OutcomeCancelled, msg)
+			if !rollback {
+				return
+			}
+			control.clearCancel()
+		} else {
+			state.fail(OutcomeFailed, err.Error())
+		}
+		// 升级失败，替换了原有副本的回滚到升级前的版本，新增的副本停止
+		replaced, _ := splitRollback(state.Images[:dispatched], state.Running)
+		state.RollbackImages = rollbackImages(s.serviceConfigs()[serviceName], state.PreviousCommit, replaced)
+		state.Status = RolloutRollback
+		state.save()
+	}
+	if state.Status == RolloutRollback {
+		s.rollback(state, control)
+	}
+}
+
+// rollNewImages 下发新版本镜像，金丝雀策略时先下发第一个副本，观察健康后再下发其余副本
+// 返回已下发的镜像数量
+func (s *Scheduler) rollNewImages(state *RolloutState, control *rolloutControl) (int, error) {
+	serviceName := state.ServiceName
+	commitHash := state.CommitHash
+	hooks := rollHooks{
+		progress: func(dispatched, confirmed int) {
+			state.Index, state.Confirmed = dispatched, confirmed
+			state.save()
+		},
+		feedback: func(feedback brisk.RollingFeedback) {
+			state.Replicas = append(state.Replicas, newReplicaRecord(PhaseRollout, feedback))
+		},
+		control: control,
+	}
+	// 中断前已下发但没有收到反馈的镜像，重新下发
+	start := state.Confirmed
+	if state.Index > start {
+		msg := fmt.Sprintf("Rolling-Resume: service: %s, commitHash: %s, resume from replica %d/%d \n", serviceName, commitHash, start+1, len(state.Images))
+		log.Print(msg)
+		s.emit(Event{Type: EventRolloutResumed, Severity: SeverityInfo, ServiceName: serviceName, CommitHash: commitHash, Message: msg})
+	}
+	strategy := s.serviceConfigs()[serviceName].Strategy
+	if strategy.Type == brisk.StrategyCanary && !state.CanaryPassed {
+		dispatched, err := s.rollImages(serviceName, commitHash, state.Images[:1], start, 1, hooks)
+		if err != nil {
+			return dispatched, err
+		}
+		if err := s.soakCanary(state.Images[0], strategy, control); err != nil {
+			return dispatched, err
+		}
+		state.CanaryPassed = true
+		state.save()
+		start = 1
+	}
+	return s.rollImages(serviceName, commitHash, state.Images, start, batchSize(strategy), hooks)
+}
+
+// batchSize 每批同时升级的副本数量 MaxUnavailable，至少为1
+func batchSize(strategy brisk.Strategy) int {
+	if strategy.MaxUnavailable < 1 {
+		return 1
+	}
+	return strategy.MaxUnavailable
+}
+
+// rollHooks 下发镜像过程中的回调，字段为 nil 时忽略
+type rollHooks struct {
+	// progress 进度变化时调用 progress(已下发数量, 已确认成功数量)
+	progress func(dispatched, confirmed int)
+	// feedback 收到keeper对每个副本的反馈
+	feedback func(feedback brisk.RollingFeedback)
+	// control 每批下发之前检查暂停与取消，等待反馈期间取消则立即返回 errRolloutCancelled
+	control *rolloutControl
+	// rolloutCommit 回滚时为本次升级的版本号：事件的 CommitHash 为 rolloutCommit，TargetCommit 为回滚的目标版本
+	rolloutCommit string
+}
+
+// rollImages 从 start 开始分批下发镜像到etcd，每批 batch 个，等待keeper通过 rolling-update-"DockerImage.ID" 反馈本批每个副本的启动结果，
+// 全部成功后再下发下一批，任意一个副本失败则停止升级
+// 返回已下发的镜像数量（包括启动失败的批次），全部成功时 error 为 nil
+func (s *Scheduler) rollImages(serviceName string, commitHash string, dockerImages []brisk.DockerImage, start int, batch int, hooks rollHooks) (int, error) {
+	control := hooks.control
+	// newEvent 下发过程中的事件，事件流的订阅者按照本次升级的版本号过滤，回滚的事件同样可以收到
+	newEvent := func(eventType, severity, node, msg string) Event {
+		e := Event{Type: eventType, Severity: severity, ServiceName: serviceName, CommitHash: commitHash, Node: node, Message: msg}
+		if hooks.rolloutCommit != "" {
+			e.CommitHash, e.TargetCommit = hooks.rolloutCommit, commitHash
+		}
+		return e
+	}
+	if len(dockerImages) <= start {
+		return len(dockerImages), nil
+	}
+	// 监听keeper 对于镜像启动情况的反馈
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := clientv3.NewWatcher(cli).Watch(ctx, brisk.RollingFeedbackPrefix, clientv3.WithPrefix())
+	index := start
+	for index < len(dockerImages) {
+		if err := control.wait(s); err != nil {
+			return index, err
+		}
+		end := index + batch
+		if end > len(dockerImages) {
+			end = len(dockerImages)
+		}
+		batchImages := dockerImages[index:end]
+		// pending 本批次等待反馈的镜像，key 为 DockerImage.ID
+		pending := make(map[string]brisk.DockerImage)
+		for _, d := range batchImages {
+			msg := fmt.Sprintf("Rolling-Info: put dockerImage to etcd, dockerImage-Info: %v \n", d)
+			log.Print(msg)
+			s.emit(newEvent(EventImageDispatched, SeverityInfo, d.Node, msg))
+			// 删除可能遗留的同一镜像的反馈信息
+			cli.Delete(context.Background(), brisk.RollingFeedbackPrefix+d.ID)
+			err := putDockerImage(d)
+			if err != nil {
+				msg := fmt.Sprintf("Rolling-Error : Put dockerImage error , dockerImage-fullName: %s, err : %v \n", d.FullName, err)
+				log.Print(msg)
+				s.emit(newEvent(EventDispatchFailed, SeverityError, d.Node, msg))
+				return index, err
+			}
+			pending[d.ID] = d
+			//index自增
+			index++
+			if hooks.progress != nil {
+				hooks.progress(index, end-len(batchImages))
+			}
+		}
+		// 等待本批次所有副本的反馈，超时从本批次下发完成时开始计算，其他服务的反馈不会重置
+		timeout := time.NewTimer(feedbackTimeout)
+		for len(pending) > 0 {
+			select {
+			case <-control.watch():
+				if cancelled, _ := control.cancelRequested(); cancelled {
+					msg := fmt.Sprintf("Rolling-Cancelled: service: %s, stop waiting for feedback, node: %s \n", serviceName, pendingNodes(pending))
+					log.Print(msg)
+					s.emit(newEvent(EventRolloutCancelled, SeverityWarning, "", msg))
+					return index, errRolloutCancelled
+				}
+			case watchResponse := <-w:
+				// 得到镜像 启动运行信息
+				for _, event := range watchResponse.Events {
+					if event.Type != mvccpb.PUT {
+						continue
+					}
+					imageID := strings.TrimPrefix(string(event.Kv.Key), brisk.RollingFeedbackPrefix)
+					d, ok := pending[imageID]
+					if !ok {
+						continue
+					}
+					cli.Delete(context.Background(), string(event.Kv.Key))
+					var feedback brisk.RollingFeedback
+					err := json.Unmarshal(event.Kv.Value, &feedback)
+					if err != nil {
+						msg := fmt.Sprintf("Rolling-Error : %s result format error, node: %s, err : %v \n", string(event.Kv.Key), d.Node, err)
+						s.emit(newEvent(EventReplicaFailed, SeverityError, d.Node, msg))
+						log.Print(msg)
+						return index, err
+					}
+					if hooks.feedback != nil {
+						hooks.feedback(feedback)
+					}
+					if !feedback.Success {
+						msg := fmt.Sprintf("Rolling-Serv-Fail: service: %s , commitHash: %s, replicas run failed, node: %s, imageID: %s, err: %s, cost: %v \n",
+							serviceName, commitHash, feedback.Node, imageID, feedback.Error, feedback.EndTime.Sub(feedback.StartTime))
+						log.Print(msg)
+						s.emit(newEvent(EventReplicaFailed, SeverityError, feedback.Node, msg))
+						return index, errors.New(msg)
+					}
+					msg := fmt.Sprintf("Rolling-Serv-Successful: service: %s, commitHash: %s, replicas run successfully, node: %s, imageID: %s, containerID: %s, cost: %v \n",
+						serviceName, commitHash, feedback.Node, imageID, feedback.ContainerID, feedback.EndTime.Sub(feedback.StartTime))
+					log.Print(msg)
+					s.emit(newEvent(EventReplicaSucceeded, SeverityInfo, feedback.Node, msg))
+					delete(pending, imageID)
+				}
+			case <-timeout.C:
+				// 超时处理
+				msg := fmt.Sprintf("Rolling-TimeOut : service: %s, rolling update timeout, no feedback node: %s \n", serviceName, pendingNodes(pending))
+				log.Print(msg)
+				s.emit(newEvent(EventFeedbackTimeout, SeverityError, pendingNodes(pending), msg))
+				return index, errors.New(msg)
+			}
+		}
+		timeout.Stop()
+		if hooks.progress != nil {
+			hooks.progress(index, index)
+		}
+	}
+	return index, nil
+}
+
+// pendingNodes 还未反馈的镜像所在的节点，以逗号分隔
+func pendingNodes(pending map[string]brisk.DockerImage) string {
+	var nodes []string
+	for _, d := range pending {
+		nodes = append(nodes, d.Node)
+	}
+	sort.Strings(nodes)
+	return strings.Join(nodes, ",")
+}
+
+// rollbackImages 为本次升级替换了原有副本的镜像，设计回滚到 previousCommit 的镜像
+func rollbackImages(servConfig brisk.ServConfigs, previousCommit string, replaced []brisk.DockerImage) []brisk.DockerImage {
+	if previousCommit == "" {
+		return nil
+	}
+	fullName := fmt.Sprintf("%s:%s", servConfig.Meta.ImagePrefix, previousCommit)
+	var images []brisk.DockerImage
+	for _, d := range replaced {
+		env := make(map[string]string)
+		for key, value := range d.Env {
+			env[key] = value
+		}
+		images = append(images, brisk.DockerImage{
+			ID:         fmt.Sprintf("%s", xid.New()),
+			FullName:   fullName,
+			Env:        env,
+			Node:       d.Node,
+			CreateTime: time.Now(),
+			Spec:       d.Spec,
+			Replica:    d.Replica,
+			Secrets:    d.Secrets,
+		})
+	}
+	return images
+}
+
+// rollback 将已下发的副本恢复到升级前的状态：替换了原有副本的，回滚到升级前的版本；本次升级新增的副本，要求keeper停止
+// 回滚同样使用 docker-image-* / rolling-update-* 的下发与反馈流程
+func (s *Scheduler) rollback(state *RolloutState, control *rolloutControl) {
+	serviceName := state.ServiceName
+	previousCommit := state.PreviousCommit
+	if state.Index == 0 {
+		return
+	}
+	replaced, added := splitRollback(state.Images[:state.Index], state.Running)
+	for _, d := range added {
+		stop := brisk.StopImage{ServiceName: serviceName, Reason: "rollback: replica added by the failed rolling-update", Replica: d.Replica}
+		event := Event{Type: EventReplicaStopped, Severity: SeverityWarning, ServiceName: serviceName, CommitHash: state.CommitHash, Node: d.Node}
+		event.Message = fmt.Sprintf("Rolling-Rollback: service: %s, stop the replica %s added on node: %s \n", serviceName, replicaKeyOf(d), d.Node)
+		if err := putStopImage(d.Node, stop); err != nil {
+			event.Type, event.Severity = EventRollbackFailed, SeverityError
+			event.Message = fmt.Sprintf("Rolling-Rollback-Fail: service: %s, stop the replica %s added on node: %s error, err: %v \n", serviceName, replicaKeyOf(d), d.Node, err)
+		}
+		log.Print(event.Message)
+		s.emit(event)
+	}
+	if len(replaced) == 0 {
+		return
+	}
+	if len(state.RollbackImages) == 0 {
+		msg := fmt.Sprintf("Rolling-Rollback-Fail: service: %s, previous commitHash is unknown, can not rollback, replicas: %d \n", serviceName, len(replaced))
+		log.Print(msg)
+		s.emit(Event{Type: EventRollbackFailed, Severity: SeverityError, ServiceName: serviceName, CommitHash: state.CommitHash, Message: msg})
+		state.Rollback = RollbackFailed
+		state.Failures = append(state.Failures, msg)
+		return
+	}
+	msg := fmt.Sprintf("Rolling-Rollback: service: %s, rollback %d replicas to commitHash: %s \n", serviceName, len(state.RollbackImages), previousCommit)
+	log.Print(msg)
+	s.emit(Event{Type: EventRollbackStarted, Severity: SeverityWarning, ServiceName: serviceName, CommitHash: state.CommitHash, TargetCommit: previousCommit, Message: msg})
+	strategy := s.serviceConfigs()[serviceName].Strategy
+	_, err := s.rollImages(serviceName, previousCommit, state.RollbackImages, state.RollbackConfirmed, batchSize(strategy), rollHooks{
+		progress: func(dispatched, confirmed int) {
+			state.RollbackIndex, state.RollbackConfirmed = dispatched, confirmed
+			state.save()
+		},
+		feedback: func(feedback brisk.RollingFeedback) {
+			state.Replicas = append(state.Replicas, newReplicaRecord(PhaseRollback, feedback))
+		},
+		control:       control,
+		rolloutCommit: state.CommitHash,
+	})
+	event := Event{Type: EventRollbackSucceeded, Severity: SeverityWarning, ServiceName: serviceName, CommitHash: state.CommitHash, TargetCommit: previousCommit}
+	if err != nil {
+		msg = fmt.Sprintf("Rolling-Rollback-Fail: service: %s, commitHash: %s, err: %v \n", serviceName, previousCommit, err)
+		state.Rollback = RollbackFailed
+		state.Failures = append(state.Failures, msg)
+		event.Type, event.Severity = EventRollbackFailed, SeverityError
+	} else {
+		msg = fmt.Sprintf("Rolling-Rollback-Successful: service: %s, all replaced replicas rolled back to commitHash: %s \n", serviceName, previousCommit)
+		state.Rollback = RollbackSuccess
+	}
+	log.Print(msg)
+	event.Message = msg
+	s.emit(event)
+}
+
+// splitRollback 区分已下发的副本：replaced 替换了节点上原有的副本，added 为本次升级新增的副本
+// running 为升级前运行着的此服务副本（见 replicaID），为 nil 时（读取失败）无法区分，全部按照 replaced 回滚；
+// 之前版本的 center 保存的升级状态以节点为 key，同样可以识别
+func splitRollback(touched []brisk.DockerImage, running map[string]bool) (replaced, added []brisk.DockerImage) {
+	for _, d := range touched {
+		if running == nil || running[replicaID(d.Node, replicaKeyOf(d))] || running[d.Node] {
+			replaced = append(replaced, d)
+		} else {
+			added = append(added, d)
+		}
+	}
+	return replaced, added
+}
+
+// replicaID 副本在所有节点中的标识：节点/副本 key
+func replicaID(hostName, key string) string {
+	return hostName + "/" + key
+}
+
+// replicaKeyOf 镜像对应的副本 key，之前版本设计的镜像没有 Replica，为服务名
+func replicaKeyOf(d brisk.DockerImage) string {
+	if d.Replica != "" {
+		return d.Replica
+	}
+	return d.Env["ServiceName"]
+}
+
+// runningReplicas 升级前运行着的此服务副本，回滚时用于区分原有的副本与新增的副本
+// 有keeper的节点读取失败时返回 nil
+func runningReplicas(serviceName string) map[string]bool {
+	keeperHost, err := getAllNolKeeper()
+	if err != nil {
+		return nil
+	}
+	nodeImages := getAllNodeImages(keeperHost, "Rolling")
+	if len(nodeImages) < len(keeperHost) {
+		return nil
+	}
+	running := make(map[string]bool)
+	for hostName, images := range nodeImages {
+		for _, key := range images.Replicas(serviceName) {
+			running[replicaID(hostName, key)] = true
+		}
+	}
+	return running
+}
+
+// putStopImage 要求节点上的keeper停止服务容器 stop-image-"HostName"-"xID"
+func putStopImage(hostName string, stop brisk.StopImage) error {
+	value, err := json.Marshal(stop)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s-%s", brisk.StopImagePrefix, hostName, xid.New())
+	_, err = cli.Put(context.Background(), key, string(value))
+	return err
+}
+
+// getServiceCommit 获取服务当前运行的版本号 center-service-commit-"ServiceName"
+// etcd 中没有记录时，从 keeper-"HostName"-image 中正在运行的副本推断
+func getServiceCommit(serviceName string) string {
+	resp, err := cli.Get(context.Background(), "center-service-commit-"+serviceName)
+	if err != nil {
+		log.Printf("Rolling-Error: get service commit error, service: %s, err: %v \n", serviceName, err)
+	} else if len(resp.Kvs) > 0 && len(resp.Kvs[0].Value) > 0 {
+		return string(resp.Kvs[0].Value)
+	}
+	keeperHost, err := getAllNolKeeper()
+	if err != nil {
+		return ""
+	}
+	for _, nodeImage := range getAllSuccService(keeperHost, "Rolling")[serviceName] {
+		_, version, err := brisk.SplitFullName(nodeImage.FullName)
+		if err == nil {
+			return version
+		}
+	}
+	return ""
+}
+
+// putServiceCommit 升级成功后，记录服务当前运行的版本号
+func putServiceCommit(serviceName string, commitHash string) {
+	_, err := cli.Put(context.Background(), "center-service-commit-"+serviceName, commitHash)
+	if err != nil {
+		log.Printf("Rolling-Error: put service commit error, service: %s, err: %v \n", serviceName, err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitRollback(t *testing.T) {
+	touched := []brisk.DockerImage{{ID: "1", Node: "node1"}, {ID: "2", Node: "node2"}, {ID: "3", Node: "node3"}}
+
+	replaced, added := splitRollback(touched, map[string]bool{"node1": true, "node3": true})
+	assert.Equal(t, []brisk.DockerImage{touched[0], touched[2]}, replaced)
+	assert.Equal(t, []brisk.DockerImage{touched[1]}, added)
+
+	// 第一次部署，节点上都没有原有的副本
+	replaced, added = splitRollback(touched, map[string]bool{})
+	assert.Empty(t, replaced)
+	assert.Equal(t, touched, added)
+
+	// 读取节点信息失败时不停止任何副本
+	replaced, added = splitRollback(touched, nil)
+	assert.Equal(t, touched, replaced)
+	assert.Empty(t, added)
+
+	// 动态端口的服务同一节点上有多个副本，按照副本 key 区分
+	env := map[string]string{"ServiceName": "hello"}
+	touched = []brisk.DockerImage{
+		{ID: "4", Node: "node1", Env: env},
+		{ID: "5", Node: "node1", Env: env, Replica: "hello#1"},
+		{ID: "6", Node: "node2", Env: env, Replica: "hello#1"},
+	}
+	replaced, added = splitRollback(touched, map[string]bool{replicaID("node1", "hello"): true, replicaID("node2", "hello#1"): true})
+	assert.Equal(t, []brisk.DockerImage{touched[0], touched[2]}, replaced)
+	assert.Equal(t, []brisk.DockerImage{touched[1]}, added)
+}
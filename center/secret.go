@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// secretBox 加密服务引用的密钥，环境变量 SecretKey 为 base64 编码的32字节密钥，与 keeper 相同；未配置时不能写入密钥
+var secretBox, secretErr = brisk.NewSecretBox(os.Getenv("SecretKey"))
+
+// secretInfo 密钥的名称，修改时间以及引用此密钥的服务，不包含密钥的值
+type secretInfo struct {
+	Name       string    `json:"name"`
+	UpdateTime time.Time `json:"update_time"`
+	Services   []string  `json:"services"`
+}
+
+// putSecret 加密并保存密钥 secret-"Name"，已存在时覆盖，之后启动的容器使用新的值
+func putSecret(name, value string) (time.Time, error) {
+	if secretErr != nil {
+		return time.Time{}, secretErr
+	}
+	sealed, err := secretBox.Seal(name, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	secret := brisk.Secret{Name: name, Value: sealed, UpdateTime: time.Now()}
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = cli.Put(context.Background(), brisk.SecretPrefix+name, string(data))
+	return secret.UpdateTime, err
+}
+
+// getSecretTimes 所有密钥的修改时间，key 为密钥名称
+func getSecretTimes() (map[string]time.Time, error) {
+	resp, err := cli.Get(context.Background(), brisk.SecretPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	times := make(map[string]time.Time)
+	for _, kv := range resp.Kvs {
+		var secret brisk.Secret
+		if err := json.Unmarshal(kv.Value, &secret); err != nil {
+			continue
+		}
+		times[secret.Name] = secret.UpdateTime
+	}
+	return times, nil
+}
+
+// secretUsers 引用密钥的服务，按服务名排序
+func (s *Scheduler) secretUsers(name string) []string {
+	var services []string
+	for serviceName, servConfig := range s.serviceConfigs() {
+		for _, secret := range servConfig.Secrets {
+			if secret == name {
+				services = append(services, serviceName)
+				break
+			}
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// checkSecrets 服务引用的密钥是否都存在，升级以及修复下发镜像之前检查，避免 keeper 启动容器时才失败
+func checkSecrets(servConfig brisk.ServConfigs) error {
+	for env, name := range servConfig.Secrets {
+		resp, err := cli.Get(context.Background(), brisk.SecretPrefix+name, clientv3.WithCountOnly())
+		if err != nil {
+			return err
+		}
+		if resp.Count == 0 {
+			return fmt.Errorf("service %s: secret %s (env %s) does not exist", servConfig.ServiceName, name, env)
+		}
+	}
+	return nil
+}
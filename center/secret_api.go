@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"brisk"
+
+	"github.com/labstack/echo"
+)
+
+// initSecretAPI 密钥的写入，查询（只有名称以及修改时间）与删除，请求头 Authorization: Bearer "BriskSecret"
+func (s *Scheduler) initSecretAPI() {
+	g := s.HTTPServer.Group("/api/secrets", bearerAuth("Secret"))
+	g.GET("", s.listSecrets)
+	g.PUT("/:name", s.putSecret)
+	g.DELETE("/:name", s.deleteSecret)
+}
+
+func (s *Scheduler) listSecrets(c echo.Context) error {
+	times, err := getSecretTimes()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	infos := []secretInfo{}
+	for name, updateTime := range times {
+		infos = append(infos, secretInfo{Name: name, UpdateTime: updateTime, Services: s.secretUsers(name)})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return c.JSON(http.StatusOK, infos)
+}
+
+// putSecret 请求体 {"value": "..."}，返回密钥信息，不返回值
+func (s *Scheduler) putSecret(c echo.Context) error {
+	name := c.Param("name")
+	if !brisk.ValidSecretName(name) {
+		return echo.NewHTTPError(http.StatusBadRequest, "secret name may only contain letters, digits, '.', '_' and '-'")
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if body.Value == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "value is required")
+	}
+	if secretErr != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, secretErr.Error())
+	}
+	updateTime, err := putSecret(name, body.Value)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	log.Printf("Secret-Info: secret %s updated, remote: %s \n", name, c.RealIP())
+	return c.JSON(http.StatusOK, secretInfo{Name: name, UpdateTime: updateTime, Services: s.secretUsers(name)})
+}
+
+// deleteSecret 仍被服务引用时返回 409
+func (s *Scheduler) deleteSecret(c echo.Context) error {
+	name := c.Param("name")
+	if services := s.secretUsers(name); len(services) > 0 {
+		return echo.NewHTTPError(http.StatusConflict, "secret is used by services: "+strings.Join(services, ","))
+	}
+	resp, err := cli.Delete(context.Background(), brisk.SecretPrefix+name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if resp.Deleted == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "secret does not exist")
+	}
+	log.Printf("Secret-Info: secret %s deleted, remote: %s \n", name, c.RealIP())
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"brisk"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// 滚动升级状态在etcd中的前缀：
+// center-rollout-state-"ServiceName" 正在进行的升级状态 RolloutState；
+// center-rollout-queue-"ServiceName" 等待升级的镜像事件队列 []ServiceImageEvent
+const (
+	rolloutStatePrefix = "center-rollout-state-"
+	rolloutQueuePrefix = "center-rollout-queue-"
+)
+
+// 滚动升级的状态
+const (
+	// RolloutPending 收到镜像事件，还未设计镜像
+	RolloutPending = "pending"
+	// RolloutRolling 正在逐个下发新版本镜像
+	RolloutRolling = "rolling"
+	// RolloutRollback 升级失败，正在回滚已下发的副本
+	RolloutRollback = "rollback"
+)
+
+// RolloutState 一次滚动升级的状态，每次状态变化都同步到etcd，center重启后据此恢复
+type RolloutState struct {
+	ServiceName    string    `json:"service_name"`
+	CommitHash     string    `json:"commit_hash"`
+	CreateTime     time.Time `json:"create_time"`
+	PreviousCommit string    `json:"previous_commit"` // 升级前的版本号，回滚使用
+	Status         string    `json:"status"`
+	// Running 升级前运行着的此服务副本（节点/副本 key），回滚时区分原有的副本与新增的副本，为 nil 表示读取失败
+	Running map[string]bool `json:"running"`
+	// Images 设计好的新版本镜像，Index 为已下发的数量，Confirmed 为keeper已反馈启动成功的数量
+	Images    []brisk.DockerImage `json:"images"`
+	Index     int                 `json:"index"`
+	Confirmed int                 `json:"confirmed"`
+	// CanaryPassed 金丝雀策略下，金丝雀副本已通过观察
+	CanaryPassed bool `json:"canary_passed"`
+	// RollbackImages 回滚使用的镜像，RollbackIndex 为已下发的数量，RollbackConfirmed 为已反馈成功的数量
+	RollbackImages    []brisk.DockerImage `json:"rollback_images"`
+	RollbackIndex     int                 `json:"rollback_index"`
+	RollbackConfirmed int                 `json:"rollback_confirmed"`
+	// Paused 升级已暂停，等待继续
+	Paused bool `json:"paused"`
+	// Source 触发升级的来源，Replicas 每个副本的启动结果，Outcome 升级结果，Rollback 回滚结果，Failures 失败信息；升级结束后保存到升级历史
+	Source     string          `json:"source"`
+	Replicas   []ReplicaRecord `json:"replicas"`
+	Outcome    string          `json:"outcome"`
+	Rollback   string          `json:"rollback"`
+	Failures   []string        `json:"failures"`
+	StartTime  time.Time       `json:"start_time"`
+	UpdateTime time.Time       `json:"update_time"`
+}
+
+// newRolloutState 根据镜像事件 新建升级状态
+func newRolloutState(e ServiceImageEvent) *RolloutState {
+	return &RolloutState{
+		ServiceName: e.ServiceName,
+		CommitHash:  e.CommitHash,
+		CreateTime:  e.CreateTime,
+		Status:      RolloutPending,
+		Source:      e.Source,
+		StartTime:   time.Now(),
+	}
+}
+
+// fail 记录升级结果以及失败信息
+func (r *RolloutState) fail(outcome string, msg string) {
+	r.Outcome = outcome
+	r.Failures = append(r.Failures, msg)
+}
+
+// save 同步升级状态到etcd
+func (r *RolloutState) save() {
+	r.UpdateTime = time.Now()
+	value, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Rolling-State-Error: rollout state marshal error, service: %s, err: %v \n", r.ServiceName, err)
+		return
+	}
+	_, err = cli.Put(context.Background(), rolloutStatePrefix+r.ServiceName, string(value))
+	if err != nil {
+		log.Printf("Rolling-State-Error: put rollout state error, service: %s, err: %v \n", r.ServiceName, err)
+	}
+}
+
+// remove 升级结束后 删除etcd中的升级状态
+func (r *RolloutState) remove() {
+	_, err := cli.Delete(context.Background(), rolloutStatePrefix+r.ServiceName)
+	if err != nil {
+		log.Printf("Rolling-State-Error: delete rollout state error, service: %s, err: %v \n", r.ServiceName, err)
+	}
+}
+
+// saveRollingQueue 同步服务的镜像事件队列到etcd，队列为空时删除
+func saveRollingQueue(serviceName string, queue []ServiceImageEvent) {
+	key := rolloutQueuePrefix + serviceName
+	if len(queue) == 0 {
+		cli.Delete(context.Background(), key)
+		return
+	}
+	value, err := json.Marshal(queue)
+	if err != nil {
+		log.Printf("Rolling-State-Error: rollout queue marshal error, service: %s, err: %v \n", serviceName, err)
+		return
+	}
+	_, err = cli.Put(context.Background(), key, string(value))
+	if err != nil {
+		log.Printf("Rolling-State-Error: put rollout queue error, service: %s, err: %v \n", serviceName, err)
+	}
+}
+
+// resumeRollouts center 启动时，从etcd恢复镜像事件队列以及未完成的滚动升级
+// 服务配置已不存在，或者状态无法继续的升级将被放弃
+func (s *Scheduler) resumeRollouts() {
+	resp, err := cli.Get(context.Background(), rolloutQueuePrefix, clientv3.WithPrefix())
+	if err != nil {
+		log.Printf("Rolling-Resume-Error: get rollout queue error, err: %v \n", err)
+	} else {
+		for _, kv := range resp.Kvs {
+			var queue []ServiceImageEvent
+			if err := json.Unmarshal(kv.Value, &queue); err != nil {
+				log.Printf("Rolling-Resume-Error: rollout queue format error, key: %s, err: %v \n", string(kv.Key), err)
+				continue
+			}
+			serviceName := strings.TrimPrefix(string(kv.Key), rolloutQueuePrefix)
+			s.mu.Lock()
+			s.RollingServQueue[serviceName] = queue
+			s.mu.Unlock()
+			log.Printf("Rolling-Resume: service: %s, restore queue: %v \n", serviceName, queue)
+		}
+	}
+
+	resp, err = cli.Get(context.Background(), rolloutStatePrefix, clientv3.WithPrefix())
+	if err != nil {
+		log.Printf("Rolling-Resume-Error: get rollout state error, err: %v \n", err)
+		return
+	}
+	for _, kv := range resp.Kvs {
+		state := &RolloutState{}
+		if err := json.Unmarshal(kv.Value, state); err != nil {
+			log.Printf("Rolling-Resume-Error: rollout state format error, key: %s, err: %v \n", string(kv.Key), err)
+			cli.Delete(context.Background(), string(kv.Key))
+			continue
+		}
+		if _, ok := s.serviceConfig(state.ServiceName); !ok {
+			log.Printf("Rolling-Resume: service %s is not configured, abort rollout, commitHash: %s \n", state.ServiceName, state.CommitHash)
+			state.remove()
+			continue
+		}
+		if state.Status == RolloutRolling && len(state.Images) == 0 {
+			// 还未下发任何镜像，重新设计
+			state.Status = RolloutPending
+		}
+		log.Printf("Rolling-Resume: service: %s, commitHash: %s, status: %s, index: %d \n", state.ServiceName, state.CommitHash, state.Status, state.Index)
+		s.mu.Lock()
+		s.RollingServices[state.ServiceName] = true
+		s.mu.Unlock()
+		go s.runRollout(state)
+	}
+	// 没有正在进行升级的服务，直接开始处理队列中的下一个镜像事件
+	s.mu.Lock()
+	var next []ServiceImageEvent
+	for serviceName, queue := range s.RollingServQueue {
+		if s.RollingServices[serviceName] || len(queue) == 0 {
+			continue
+		}
+		next = append(next, queue[0])
+		s.RollingServQueue[serviceName] = queue[1:]
+		saveRollingQueue(serviceName, s.RollingServQueue[serviceName])
+	}
+	s.mu.Unlock()
+	for _, e := range next {
+		s.ImageEventChan <- e
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	// subscriberBuffer 每个订阅者缓存的事件数量，订阅者读取过慢时丢弃事件
+	subscriberBuffer = 256
+	// streamHeartbeat 没有事件时发送心跳注释的间隔，避免代理断开空闲连接
+	streamHeartbeat = 15 * time.Second
+)
+
+// subscriber 事件流的一个订阅者，services 为空时接收所有服务的事件
+type subscriber struct {
+	services   []string
+	commitHash string
+	events     chan Event
+}
+
+func (sub *subscriber) wants(e Event) bool {
+	if len(sub.services) > 0 && !contains(sub.services, e.ServiceName) {
+		return false
+	}
+	return sub.commitHash == "" || e.CommitHash == "" || e.CommitHash == sub.commitHash
+}
+
+// eventHub 将升级以及修复事件分发给事件流的订阅者
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[*subscriber]struct{})}
+}
+
+func (h *eventHub) subscribe(services []string, commitHash string) *subscriber {
+	sub := &subscriber{services: services, commitHash: commitHash, events: make(chan Event, subscriberBuffer)}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// publish 分发事件，不阻塞：订阅者的缓存已满时丢弃该事件
+func (h *eventHub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			log.Printf("Stream-Error: subscriber is too slow, drop event %s of service %s \n", e.Type, e.ServiceName)
+		}
+	}
+}
+
+// publishQueue 服务的镜像事件队列变化，只发送给事件流，不作为通知，同时更新队列长度指标；调用方持有 s.mu
+func (s *Scheduler) publishQueue(serviceName string) {
+	queueDepth.WithLabelValues(serviceName).Set(float64(len(s.RollingServQueue[serviceName])))
+	var commits []string
+	for _, e := range s.RollingServQueue[serviceName] {
+		commits = append(commits, e.CommitHash)
+	}
+	s.events.publish(Event{
+		Type:        EventQueueChanged,
+		Severity:    SeverityInfo,
+		ServiceName: serviceName,
+		Message:     fmt.Sprintf("Queue-Changed: service: %s, rolling: %v, queue: %v", serviceName, s.RollingServices[serviceName], commits),
+		Time:        time.Now(),
+	})
+}
+
+// streamEvents GET /api/events?service=a,b&commit=，以 Server-Sent Events 推送升级以及修复事件
+// 每个事件为 "event: 事件类型" 以及 "data: 事件的JSON"；事件只在leader中产生，follower 重定向到leader
+func (s *Scheduler) streamEvents(c echo.Context) error {
+	if !s.IsLeader() {
+		address, err := s.leaderAddress()
+		if err != nil {
+			return c.String(http.StatusServiceUnavailable, "no leader")
+		}
+		return c.Redirect(http.StatusTemporaryRedirect, strings.TrimSuffix(address, "/")+c.Request().URL.RequestURI())
+	}
+	var services []string
+	for _, name := range strings.Split(c.QueryParam("service"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			services = append(services, name)
+		}
+	}
+	sub := s.events.subscribe(services, c.QueryParam("commit"))
+	defer s.events.unsubscribe(sub)
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(resp, ": heartbeat\n\n")
+		case e := <-sub.events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		resp.Flush()
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHubPublish(t *testing.T) {
+	hub := newEventHub()
+	all := hub.subscribe(nil, "")
+	hello := hub.subscribe([]string{"hello"}, "v2")
+
+	hub.publish(Event{Type: EventRolloutStarted, ServiceName: "hello", CommitHash: "v2"})
+	// 回滚事件的 CommitHash 为本次升级的版本，按版本订阅同样可以收到
+	hub.publish(Event{Type: EventRollbackStarted, ServiceName: "hello", CommitHash: "v2", TargetCommit: "v1"})
+	hub.publish(Event{Type: EventRolloutStarted, ServiceName: "hello", CommitHash: "v3"})
+	hub.publish(Event{Type: EventQueueChanged, ServiceName: "hello"})
+	hub.publish(Event{Type: EventRolloutStarted, ServiceName: "world", CommitHash: "v2"})
+
+	assert.Len(t, all.events, 5)
+	var types []string
+	for len(hello.events) > 0 {
+		types = append(types, (<-hello.events).Type)
+	}
+	assert.Equal(t, []string{EventRolloutStarted, EventRollbackStarted, EventQueueChanged}, types)
+
+	hub.unsubscribe(hello)
+	hub.publish(Event{Type: EventRolloutCompleted, ServiceName: "hello", CommitHash: "v2"})
+	assert.Empty(t, hello.events)
+	assert.Len(t, all.events, 6)
+}
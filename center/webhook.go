@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"brisk"
+
+	"github.com/labstack/echo"
+)
+
+// initWebhookAPI Docker Registry 以及 GitHub/GitLab/Gitea 的推送通知，转换为 ServiceImageEvent
+// 仓库名称通过服务配置的 Meta.ImagePrefix 对应到服务
+func (s *Scheduler) initWebhookAPI() {
+	g := s.HTTPServer.Group("/api/webhook", s.leaderOnly)
+	g.POST("/registry", s.registryWebhook, bearerAuth("Webhook"))
+	g.POST("/github", s.gitWebhook("github", githubPush))
+	g.POST("/gitea", s.gitWebhook("gitea", giteaPush))
+	g.POST("/gitlab", s.gitWebhook("gitlab", gitlabPush))
+}
+
+// webhookResult 每个转换得到的镜像事件的处理结果
+type webhookResult struct {
+	ServiceName string `json:"service_name"`
+	CommitHash  string `json:"commit_hash"`
+	Result      string `json:"result"` // accepted，或者拒绝的原因
+}
+
+// acceptImageEvents 依次接受镜像事件，返回每个事件的处理结果
+// 推送方失败时会重试，重复的事件不作为错误
+func (s *Scheduler) acceptImageEvents(c echo.Context, events []ServiceImageEvent) error {
+	results := []webhookResult{}
+	for _, event := range events {
+		result := "accepted"
+		if err := s.acceptImageEvent(event); err != nil {
+			result = err.Error()
+		}
+		results = append(results, webhookResult{event.ServiceName, event.CommitHash, result})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// registryNotification Docker Registry v2 的通知 https://docs.docker.com/registry/notifications/
+type registryNotification struct {
+	Events []struct {
+		Action    string    `json:"action"`
+		Timestamp time.Time `json:"timestamp"`
+		Target    struct {
+			MediaType  string `json:"mediaType"`
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+	} `json:"events"`
+}
+
+// registryWebhook Docker Registry v2 通知，镜像 tag 作为 CommitHash
+// Registry 配置 notifications.endpoints.headers 发送 Authorization: Bearer "BriskSecret"
+func (s *Scheduler) registryWebhook(c echo.Context) error {
+	// Registry 的 Content-Type 为 application/vnd.docker.distribution.events.v1+json，c.Bind 不支持，直接解析请求体
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	var notification registryNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	var events []ServiceImageEvent
+	for _, e := range notification.Events {
+		// 只处理带 tag 的 manifest 推送，layer 推送以及按 digest 推送忽略
+		if e.Action != "push" || e.Target.Tag == "" {
+			continue
+		}
+		for _, name := range s.servicesForRepository(e.Target.Repository) {
+			events = append(events, ServiceImageEvent{ServiceName: name, CommitHash: e.Target.Tag, CreateTime: e.Timestamp, Source: "registry"})
+		}
+	}
+	return s.acceptImageEvents(c, events)
+}
+
+// gitPush 从 Git 仓库推送通知中取得的信息
+type gitPush struct {
+	// Repository 仓库全名，例如 eglass/hello
+	Repository    string
+	Ref           string
+	After         string
+	DefaultBranch string
+}
+
+// gitPushParser 校验推送通知的签名并解析，event 不是推送事件时 ok 为 false
+type gitPushParser func(c echo.Context, body []byte) (push gitPush, ok bool, err error)
+
+// gitWebhook Git 仓库推送通知：推送 tag 时 tag 名称作为 CommitHash，推送默认分支时提交的 hash 作为 CommitHash，其余分支忽略
+func (s *Scheduler) gitWebhook(source string, parse gitPushParser) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		push, ok, err := parse(c, body)
+		if err != nil {
+			return err
+		}
+		var commitHash string
+		switch {
+		case !ok:
+		case strings.HasPrefix(push.Ref, "refs/tags/"):
+			commitHash = strings.TrimPrefix(push.Ref, "refs/tags/")
+		case push.Ref == "refs/heads/"+push.DefaultBranch && strings.Trim(push.After, "0") != "":
+			commitHash = push.After
+		}
+		var events []ServiceImageEvent
+		if commitHash != "" {
+			for _, name := range s.servicesForRepository(push.Repository) {
+				events = append(events, ServiceImageEvent{ServiceName: name, CommitHash: commitHash, CreateTime: time.Now(), Source: source})
+			}
+		}
+		return s.acceptImageEvents(c, events)
+	}
+}
+
+// githubPayload GitHub 以及 Gitea 的 push 事件
+type githubPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		FullName      string `json:"full_name"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+func (p githubPayload) push() gitPush {
+	return gitPush{Repository: p.Repository.FullName, Ref: p.Ref, After: p.After, DefaultBranch: p.Repository.DefaultBranch}
+}
+
+// githubPush 请求头 X-Hub-Signature-256: sha256=hex(HMAC-SHA256(BriskSecret, body))
+func githubPush(c echo.Context, body []byte) (gitPush, bool, error) {
+	if !verifySignature(body, c.Request().Header.Get("X-Hub-Signature-256")) {
+		return gitPush{}, false, echo.NewHTTPError(http.StatusUnauthorized, "signature mismatch")
+	}
+	if c.Request().Header.Get("X-GitHub-Event") != "push" {
+		return gitPush{}, false, nil
+	}
+	var payload githubPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return gitPush{}, false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return payload.push(), true, nil
+}
+
+// giteaPush 请求头 X-Gitea-Signature: hex(HMAC-SHA256(BriskSecret, body))
+func giteaPush(c echo.Context, body []byte) (gitPush, bool, error) {
+	if !verifySignature(body, "sha256="+c.Request().Header.Get("X-Gitea-Signature")) {
+		return gitPush{}, false, echo.NewHTTPError(http.StatusUnauthorized, "signature mismatch")
+	}
+	if c.Request().Header.Get("X-Gitea-Event") != "push" {
+		return gitPush{}, false, nil
+	}
+	var payload githubPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return gitPush{}, false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return payload.push(), true, nil
+}
+
+// gitlabPayload GitLab 的 Push Hook 以及 Tag Push Hook
+type gitlabPayload struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		DefaultBranch     string `json:"default_branch"`
+	} `json:"project"`
+}
+
+// gitlabPush 请求头 X-Gitlab-Token 为 BriskSecret
+func gitlabPush(c echo.Context, body []byte) (gitPush, bool, error) {
+	token := c.Request().Header.Get("X-Gitlab-Token")
+	if BriskSecret == "" || !hmac.Equal([]byte(token), []byte(BriskSecret)) {
+		return gitPush{}, false, echo.NewHTTPError(http.StatusUnauthorized, "token mismatch")
+	}
+	event := c.Request().Header.Get("X-Gitlab-Event")
+	if event != "Push Hook" && event != "Tag Push Hook" {
+		return gitPush{}, false, nil
+	}
+	var payload gitlabPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return gitPush{}, false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return gitPush{Repository: payload.Project.PathWithNamespace, Ref: payload.Ref, After: payload.After, DefaultBranch: payload.Project.DefaultBranch}, true, nil
+}
+
+// servicesForRepository 镜像仓库或代码仓库对应的服务，没有对应的服务时记录日志
+func (s *Scheduler) servicesForRepository(repository string) []string {
+	names := matchRepository(s.serviceConfigs(), repository)
+	if len(names) == 0 {
+		log.Printf("Webhook-Info: no service for repository %s \n", repository)
+	}
+	return names
+}
+
+// matchRepository 服务的 Meta.ImagePrefix 去掉 registry 地址之后与仓库名称完全相同（忽略大小写以及首尾的 /）的服务，按服务名排序
+// 不比较最后一段名称，避免 teamA/api 的推送升级 teamB/api 的服务
+func matchRepository(serviceMetas brisk.AllServConfigs, repository string) []string {
+	repository = normalizeRepository(repository)
+	var names []string
+	for name, servConfig := range serviceMetas {
+		if normalizeRepository(repositoryOfImage(servConfig.Meta.ImagePrefix)) == repository {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalizeRepository 仓库名称转为小写并去掉首尾的 /
+func normalizeRepository(repository string) string {
+	return strings.ToLower(strings.Trim(repository, "/"))
+}
+
+// repositoryOfImage 去掉镜像名称中的 registry 地址，host:5000/eglass/hello => eglass/hello
+// 与 docker 的规则相同：第一段包含 . 或 : 或为 localhost 时为 registry 地址
+func repositoryOfImage(imagePrefix string) string {
+	parts := strings.SplitN(imagePrefix, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[1]
+	}
+	return imagePrefix
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRepository(t *testing.T) {
+	serviceMetas := brisk.AllServConfigs{
+		"api":     {ServiceName: "api", Meta: brisk.Meta{ImagePrefix: "registry.example.com:5000/teamA/api"}},
+		"api-b":   {ServiceName: "api-b", Meta: brisk.Meta{ImagePrefix: "teamB/api"}},
+		"worker":  {ServiceName: "worker", Meta: brisk.Meta{ImagePrefix: "localhost/teamA/api"}},
+		"web":     {ServiceName: "web", Meta: brisk.Meta{ImagePrefix: "teamA/web"}},
+		"noimage": {ServiceName: "noimage"},
+	}
+
+	// 去掉 registry 地址之后比较，同一仓库可以对应多个服务
+	assert.Equal(t, []string{"api", "worker"}, matchRepository(serviceMetas, "teamA/api"))
+	assert.Equal(t, []string{"api", "worker"}, matchRepository(serviceMetas, "/TeamA/API/"))
+	// 只有最后一段相同的仓库不匹配
+	assert.Equal(t, []string{"api-b"}, matchRepository(serviceMetas, "teamB/api"))
+	assert.Empty(t, matchRepository(serviceMetas, "api"))
+	assert.Empty(t, matchRepository(serviceMetas, "teamC/api"))
+
+	assert.Equal(t, "eglass/hello", repositoryOfImage("host:5000/eglass/hello"))
+	assert.Equal(t, "eglass/hello", repositoryOfImage("eglass/hello"))
+}
@@ -0,0 +1,120 @@
+package brisk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 容器重启策略
+var restartPolicies = []string{"", "no", "always", "unless-stopped", "on-failure"}
+
+// ContainerSpec 启动容器的参数，由服务配置 Meta.Container 经 DockerImage 传给 keeper，对应 docker run 的参数
+type ContainerSpec struct {
+	CPUs       string            `yaml:"cpus" json:"cpus,omitempty"`              // --cpus，例如 0.5
+	Memory     string            `yaml:"memory" json:"memory,omitempty"`          // --memory，例如 512m
+	Ports      []PortMapping     `yaml:"ports" json:"ports,omitempty"`            // Port:ContainerPort 之外的端口映射
+	Volumes    []VolumeMount     `yaml:"volumes" json:"volumes,omitempty"`        // -v
+	Network    string            `yaml:"network" json:"network,omitempty"`        // --network
+	Restart    string            `yaml:"restart" json:"restart,omitempty"`        // --restart: no / always / unless-stopped / on-failure[:次数]
+	Labels     map[string]string `yaml:"labels" json:"labels,omitempty"`          // --label
+	Entrypoint string            `yaml:"entrypoint" json:"entrypoint,omitempty"`  // --entrypoint
+	Command    []string          `yaml:"command" json:"command,omitempty"`        // 镜像名之后的命令以及参数
+	LogDriver  string            `yaml:"logdriver" json:"log_driver,omitempty"`   // --log-driver
+	LogOptions map[string]string `yaml:"logoptions" json:"log_options,omitempty"` // --log-opt
+}
+
+// PortMapping 端口映射，Protocol 为空时为 tcp
+type PortMapping struct {
+	HostPort      string `yaml:"hostport" json:"host_port"`
+	ContainerPort string `yaml:"containerport" json:"container_port"`
+	Protocol      string `yaml:"protocol" json:"protocol,omitempty"`
+}
+
+// VolumeMount 挂载，Source 为宿主机路径或 volume 名称
+type VolumeMount struct {
+	Source   string `yaml:"source" json:"source"`
+	Target   string `yaml:"target" json:"target"`
+	ReadOnly bool   `yaml:"readonly" json:"read_only,omitempty"`
+}
+
+// Validate 检查容器参数
+func (c ContainerSpec) Validate() error {
+	policy := strings.SplitN(c.Restart, ":", 2)[0]
+	valid := false
+	for _, p := range restartPolicies {
+		if policy == p {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("container: unknown restart policy %q", c.Restart)
+	}
+	for _, port := range c.Ports {
+		if port.HostPort == "" || port.ContainerPort == "" {
+			return fmt.Errorf("container: hostport and containerport are required")
+		}
+		if port.Protocol != "" && port.Protocol != "tcp" && port.Protocol != "udp" {
+			return fmt.Errorf("container: unknown protocol %q", port.Protocol)
+		}
+	}
+	for _, volume := range c.Volumes {
+		if volume.Source == "" || volume.Target == "" {
+			return fmt.Errorf("container: volume source and target are required")
+		}
+	}
+	return nil
+}
+
+// RunArgs docker run 的参数（不含 docker run -d，镜像名以及命令），map 按 key 排序保证参数稳定
+func (c ContainerSpec) RunArgs() []string {
+	var args []string
+	if c.CPUs != "" {
+		args = append(args, "--cpus", c.CPUs)
+	}
+	if c.Memory != "" {
+		args = append(args, "--memory", c.Memory)
+	}
+	for _, port := range c.Ports {
+		mapping := port.HostPort + ":" + port.ContainerPort
+		if port.Protocol != "" {
+			mapping += "/" + port.Protocol
+		}
+		args = append(args, "-p", mapping)
+	}
+	for _, volume := range c.Volumes {
+		mount := volume.Source + ":" + volume.Target
+		if volume.ReadOnly {
+			mount += ":ro"
+		}
+		args = append(args, "-v", mount)
+	}
+	if c.Network != "" {
+		args = append(args, "--network", c.Network)
+	}
+	if c.Restart != "" {
+		args = append(args, "--restart", c.Restart)
+	}
+	for _, key := range sortedKeys(c.Labels) {
+		args = append(args, "--label", key+"="+c.Labels[key])
+	}
+	if c.Entrypoint != "" {
+		args = append(args, "--entrypoint", c.Entrypoint)
+	}
+	if c.LogDriver != "" {
+		args = append(args, "--log-driver", c.LogDriver)
+	}
+	for _, key := range sortedKeys(c.LogOptions) {
+		args = append(args, "--log-opt", key+"="+c.LogOptions[key])
+	}
+	return args
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -11,4 +11,20 @@ type DockerImage struct {
 	Env        map[string]string `json:env`        // 环境变量
 	Node       string            `json:name`       // 指定节点Node
 	CreateTime time.Time         `json:createTime` //镜像创建时间
+	Spec       ContainerSpec     `json:"spec"`     // 容器参数
+	// Replica 副本在 keeper-"HostName"-image 中的 key，见 ReplicaKey；为空时为镜像名
+	Replica string `json:"replica,omitempty"`
+	// Secrets 以密钥作为环境变量，key 为环境变量名，value 为密钥名称，不包含密钥的值
+	Secrets map[string]string `json:"secrets,omitempty"`
+}
+
+// StopImagePrefix center 要求keeper停止服务容器，key 为 stop-image-"HostName"-"xID"
+const StopImagePrefix = "stop-image-"
+
+// StopImage center 要求keeper停止节点上的服务容器（升级失败回滚时新增的副本，多余或者已不在配置中的副本）
+type StopImage struct {
+	ServiceName string `json:"service_name"` // 服务名
+	ContainerID string `json:"container_id"` // 容器ID，与keeper记录的不一致时不停止，为空时停止正在运行的容器
+	Reason      string `json:"reason"`       // 停止原因
+	Replica     string `json:"replica"`      // 副本 key，为空时为服务名
 }
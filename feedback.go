@@ -0,0 +1,21 @@
+package brisk
+
+import (
+	"time"
+)
+
+// RollingFeedbackPrefix keeper 对镜像启动情况的反馈，key 为 rolling-update-"DockerImage.ID"
+const RollingFeedbackPrefix = "rolling-update-"
+
+// RollingFeedback keeper 启动镜像后的反馈信息，center 据此判断对应副本是否升级成功
+type RollingFeedback struct {
+	ImageID     string    `json:"image_id"`     // 对应 DockerImage.ID
+	ServiceName string    `json:"service_name"` // 服务名
+	Node        string    `json:"node"`         // 节点HostName
+	FullName    string    `json:"full_name"`    // 镜像全名
+	ContainerID string    `json:"container_id"` // 启动成功的容器ID
+	Success     bool      `json:"success"`      // 是否启动成功
+	Error       string    `json:"error"`        // 启动失败的错误信息
+	StartTime   time.Time `json:"start_time"`   // keeper 开始处理的时间
+	EndTime     time.Time `json:"end_time"`     // keeper 处理完成的时间
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -31,7 +32,9 @@ var (
 		Endpoints:   []string{Etcd},
 		DialTimeout: 5 * time.Second,
 	})
-	keeper = newKeeper()
+	// secretBox 解密服务引用的密钥，环境变量 SecretKey 与 center 相同
+	secretBox, secretErr = brisk.NewSecretBox(os.Getenv("SecretKey"))
+	keeper               = newKeeper()
 )
 
 type Keeper struct {
@@ -110,7 +113,7 @@ func (k *Keeper) startOperation(restart bool) (map[string]brisk.NodeImage, map[s
 	if restart {
 		nodeImages = k.failNodeImages
 	}
-	for _, value := range nodeImages {
+	for key, value := range nodeImages {
 		name, version, err := brisk.SplitFullName(value.FullName)
 		if err != nil {
 			log.Printf("Error : dockerImage name has error, Image fullName %s \n", value.FullName)
@@ -120,7 +123,7 @@ func (k *Keeper) startOperation(restart bool) (map[string]brisk.NodeImage, map[s
 		err = pullImage(value.FullName)
 		if err != nil {
 			log.Printf("Error : pull image error, %v \n", err)
-			failNodeImageMap[name] = value
+			failNodeImageMap[key] = value
 			continue
 		}
 		//停止
@@ -132,20 +135,22 @@ func (k *Keeper) startOperation(restart bool) (map[string]brisk.NodeImage, map[s
 		var imageInfo brisk.ImageInfo
 		imageInfo.FullName = value.FullName
 		imageInfo.Env = value.Env
+		imageInfo.Spec = value.Spec
+		imageInfo.Secrets = value.Secrets
 		imageInfo.Node = value.Node
 		imageInfo.Name = name
 		imageInfo.Version = version
 		// 运行
-		cid, err := runImage(imageInfo.FullName, imageInfo.Env)
+		cid, err := runImage(imageInfo.FullName, imageInfo.Env, imageInfo.Spec, imageInfo.Secrets)
 		if err != nil {
 			log.Printf("Error : run image error, %v \n", err)
-			failNodeImageMap[name] = value
+			failNodeImageMap[key] = value
 			continue
 		}
 		imageInfo.ContainerID, value.ContainerID = cid, cid
 		imageInfo.CreateTime = time.Now()
 		value.ImageInfoKey = putImageInfo(value.ImageInfoKey, imageInfo)
-		successImageMap[imageInfo.Name] = value
+		successImageMap[key] = value
 	}
 	return successImageMap, failNodeImageMap
 }
@@ -169,6 +174,9 @@ func main() {
 		log.Fatal("Error: cannot connect to etcd ", etcdErr)
 	}
 	log.Println("Successful: connect to etcd")
+	if secretErr != nil {
+		log.Printf("Secret-Warning: %v, services with secrets can not be started \n", secretErr)
+	}
 	//TODO
 	log.Println("Etcd : " + Etcd + ",time : " + RestartTime)
 	// init初始化 使用场景：重启 恢复etcd里的服务  将之前保存在etcd中的全部启动后 同步到etcd上
@@ -185,6 +193,8 @@ func main() {
 	w := watcher.Watch(context.Background(), "docker-image", clientv3.WithPrefix())
 	// rm 监控服务的销毁删除，删除本地缓存上的以及etcd上的正在运行的镜像记录
 	rm := watcher.Watch(context.Background(), fmt.Sprintf("%s-%s-", "RM", hostname), clientv3.WithPrefix())
+	// stop 监控center 停止服务副本的请求 stop-image-"HostName"-*
+	stop := watcher.Watch(context.Background(), brisk.StopImagePrefix+hostname+"-", clientv3.WithPrefix())
 	// restartMin 失败服务重启时间，推荐1分钟左右,具体时间根据配置文件而定
 	restartMin, err := strconv.Atoi(RestartTime)
 	// keeperStarted 向etcd put运行成功的keeper
@@ -220,17 +230,18 @@ func main() {
 						continue
 					}
 					log.Println("Keeper: update-image start")
-					err = updateImage(dockerImage)
+					startTime := time.Now()
+					containerID, err := updateImage(dockerImage)
 					// 启动镜像失败
 					if err != nil {
 						log.Printf("Error : updateImage has error,err:%v ,docker-image Key :%s \n", err, string(event.Kv.Key))
 						log.Printf("Info: Send rolling-update failure info to the center \n")
-						feedbackUpdateImage("false", dockerImage.Env["ServiceName"])
+						feedbackUpdateImage(dockerImage, containerID, startTime, err)
 						continue
 					}
 					// 启动镜像成功
 					log.Printf("Info: Send rolling-update success info to the center \n")
-					feedbackUpdateImage("true", dockerImage.Env["ServiceName"])
+					feedbackUpdateImage(dockerImage, containerID, startTime, nil)
 				}
 			}
 		// rmwatchResponse 监控服务容器 销毁失败
@@ -246,25 +257,36 @@ func main() {
 					containerID := value["containerId"]
 					log.Printf("Keeper-Info : serviceName : %s, containerId : %s  \n", serviceName, containerID)
 					// 若需要删除的镜像服务，本地NodeImageCache存在，删除本地记录 同步到etcd上
-					if nodeImage, ok := keeper.successNodeImages[serviceName]; ok {
-						// 取到该服务的成功运行记录，与当前监控到的服务删除记录 containId是否一致，一致则删除成功记录；否则，不删除
-						cID := string([]rune(nodeImage.ContainerID)[:12])
-						log.Printf("Keeper-Info : successNodeImages: serviceName : %s, containerId : %s  \n", serviceName, cID)
-						if cID == containerID {
-							// 加入到重启缓存中
-							log.Println("Keeper-Info : the service record add to keeper (failNodeImages)")
-							keeper.failNodeImages[serviceName] = keeper.successNodeImages[serviceName]
-							log.Println("Keeper-Info : remove service record from keeper (successNodeImages)")
-							delete(keeper.successNodeImages, serviceName)
-							log.Println("Keeper-Info : successNodeImages sync to etcd")
-							keeper.syncNodeImage()
-							// 删除 service-nodeImage 的 remove记录
-							log.Printf("Keeper-Info : delete rm-info from etcd \n")
-							cli.Delete(context.Background(), string(event.Kv.Key))
-						}
+					// 取到该服务的成功运行记录，与当前监控到的服务删除记录 containId是否一致，一致则删除成功记录；否则，不删除
+					if key, ok := keeper.findReplica(serviceName, containerID); ok {
+						// 加入到重启缓存中
+						log.Printf("Keeper-Info : the service record add to keeper (failNodeImages), replica : %s \n", key)
+						keeper.failNodeImages[key] = keeper.successNodeImages[key]
+						log.Println("Keeper-Info : remove service record from keeper (successNodeImages)")
+						delete(keeper.successNodeImages, key)
+						log.Println("Keeper-Info : successNodeImages sync to etcd")
+						keeper.syncNodeImage()
+						// 删除 service-nodeImage 的 remove记录
+						log.Printf("Keeper-Info : delete rm-info from etcd \n")
+						cli.Delete(context.Background(), string(event.Kv.Key))
 					}
 				}
 			}
+		// stopWatchResponse center 要求停止本节点上的服务副本
+		case stopWatchResponse := <-stop:
+			for _, event := range stopWatchResponse.Events {
+				if event.Type != mvccpb.PUT {
+					continue
+				}
+				var stopImage brisk.StopImage
+				if err := json.Unmarshal(event.Kv.Value, &stopImage); err != nil {
+					log.Printf("Error : etcd registered format error ,err : %v, stop-image Key :%s \n", err, string(event.Kv.Key))
+				} else {
+					log.Printf("Keeper-Info : keeper has a stop task, serviceName : %s, containerId : %s, reason : %s \n", stopImage.ServiceName, stopImage.ContainerID, stopImage.Reason)
+					keeper.stopNodeImage(stopImage)
+				}
+				cli.Delete(context.Background(), string(event.Kv.Key))
+			}
 		case <-restartTicker.C:
 			if len(keeper.failNodeImages) > 0 {
 				// 进行重启
@@ -279,9 +301,68 @@ func main() {
 	}
 }
 
-// 为滚动升级 反馈镜像的执行信息
-func feedbackUpdateImage(isSuccessful string, serviceName string) {
-	_, err := cli.Put(context.Background(), "rolling-update-"+serviceName, isSuccessful)
+// findReplica 服务容器退出时，按服务名以及容器ID（容器内的 hostname，前12位）找到本地记录的副本 key
+func (k *Keeper) findReplica(serviceName, containerID string) (string, bool) {
+	for _, key := range k.successNodeImages.Replicas(serviceName) {
+		cID := k.successNodeImages[key].ContainerID
+		if len(cID) > 12 {
+			cID = cID[:12]
+		}
+		log.Printf("Keeper-Info : successNodeImages: replica : %s, containerId : %s  \n", key, cID)
+		if cID == containerID {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// stopNodeImage 停止服务容器，并删除本地以及etcd上的运行记录，重启时不再启动
+// 本地记录的容器ID 与请求中的不一致时，说明服务已被更新，不停止
+func (k *Keeper) stopNodeImage(stop brisk.StopImage) {
+	key := stop.Replica
+	if key == "" {
+		key = stop.ServiceName
+	}
+	nodeImage, ok := k.successNodeImages[key]
+	if !ok {
+		log.Printf("Keeper-Info : service %s is not running on this node, remove the record from failNodeImages \n", key)
+		delete(k.failNodeImages, key)
+		return
+	}
+	if stop.ContainerID != "" && nodeImage.ContainerID != stop.ContainerID {
+		log.Printf("Keeper-Info : service %s containerId changed, running : %s, stop : %s, skip \n", key, nodeImage.ContainerID, stop.ContainerID)
+		return
+	}
+	if err := stopImage(nodeImage.ContainerID); err != nil {
+		log.Printf("Error : stop service %s error, containerId : %s, err : %v \n", key, nodeImage.ContainerID, err)
+		return
+	}
+	delete(k.successNodeImages, key)
+	delete(k.failNodeImages, key)
+	k.syncNodeImage()
+}
+
+// 为滚动升级 反馈镜像的执行信息 rolling-update-"DockerImage.ID"
+func feedbackUpdateImage(dockerImage brisk.DockerImage, containerID string, startTime time.Time, updateErr error) {
+	feedback := brisk.RollingFeedback{
+		ImageID:     dockerImage.ID,
+		ServiceName: dockerImage.Env["ServiceName"],
+		Node:        dockerImage.Node,
+		FullName:    dockerImage.FullName,
+		ContainerID: containerID,
+		Success:     updateErr == nil,
+		StartTime:   startTime,
+		EndTime:     time.Now(),
+	}
+	if updateErr != nil {
+		feedback.Error = updateErr.Error()
+	}
+	value, err := json.Marshal(feedback)
+	if err != nil {
+		log.Printf("Error : feedback rolling-update info marshal error, err : %v \n", err)
+		return
+	}
+	_, err = cli.Put(context.Background(), brisk.RollingFeedbackPrefix+dockerImage.ID, string(value))
 	if err != nil {
 		log.Printf("Error : feedback rolling-update info error, failed to send , err : %v \n", err)
 		return
@@ -289,11 +370,17 @@ func feedbackUpdateImage(isSuccessful string, serviceName string) {
 	log.Printf("Successful: feedback rolling-update info ok, send  successfully \n")
 }
 
-func updateImage(dockerImage brisk.DockerImage) error {
+// updateImage 拉取并启动新镜像，返回新容器ID
+func updateImage(dockerImage brisk.DockerImage) (string, error) {
 	var imageInfo brisk.ImageInfo
 	log.Println("Converter start")
 	imageInfo.Converter(dockerImage)
-	infoKey, containerID := getOldImageInfo(imageInfo.Name, imageInfo.Node)
+	// 副本 key，同一节点上同一服务的多个副本以此区分
+	replica := dockerImage.Replica
+	if replica == "" {
+		replica = imageInfo.Name
+	}
+	infoKey, containerID := getOldImageInfo(replica, imageInfo.Node)
 	// 准备fail信息，若失败使用，添加入本地失败的缓存内；反之，搁置不用
 	failNodeImage := brisk.NodeImage{
 		ImageInfoKey: infoKey,
@@ -301,14 +388,16 @@ func updateImage(dockerImage brisk.DockerImage) error {
 		FullName:     imageInfo.FullName,
 		Env:          imageInfo.Env,
 		ContainerID:  imageInfo.ContainerID,
+		Spec:         imageInfo.Spec,
+		Secrets:      imageInfo.Secrets,
 	}
 	// pull新镜像
 	log.Println("Pull: pullImage start")
 	err := pullImage(dockerImage.FullName)
 	if err != nil {
 		log.Printf("Pull : pull image error, %v \n", err)
-		keeper.failNodeImages[imageInfo.Name] = failNodeImage
-		return err
+		keeper.failNodeImages[replica] = failNodeImage
+		return "", err
 	}
 	log.Println("Pull: pullImage finished")
 
@@ -323,31 +412,33 @@ func updateImage(dockerImage brisk.DockerImage) error {
 	}
 	//run 新镜像
 	log.Println("Run: runImage start")
-	cid, err := runImage(imageInfo.FullName, imageInfo.Env)
+	cid, err := runImage(imageInfo.FullName, imageInfo.Env, imageInfo.Spec, imageInfo.Secrets)
 	if err != nil {
 		log.Printf("Run : run image error, %v \n", err)
-		keeper.failNodeImages[imageInfo.Name] = failNodeImage
-		return err
+		keeper.failNodeImages[replica] = failNodeImage
+		return "", err
 	}
 	log.Println("Run: runImage ok")
 	//删除可能存在的启动失败的旧镜像信息
-	if _, ok := keeper.failNodeImages[imageInfo.Name]; ok {
-		delete(keeper.failNodeImages, imageInfo.Name)
+	if _, ok := keeper.failNodeImages[replica]; ok {
+		delete(keeper.failNodeImages, replica)
 	}
 	imageInfo.ContainerID = cid
 	imageInfo.CreateTime = time.Now()
 	//put 新镜像容器的信息
 	key := putImageInfo(infoKey, imageInfo)
 
-	keeper.successNodeImages[imageInfo.Name] = brisk.NodeImage{
+	keeper.successNodeImages[replica] = brisk.NodeImage{
 		ImageInfoKey: key,
 		FullName:     imageInfo.FullName,
 		Env:          imageInfo.Env,
 		Node:         imageInfo.Node,
 		ContainerID:  imageInfo.ContainerID,
+		Spec:         imageInfo.Spec,
+		Secrets:      imageInfo.Secrets,
 	}
 	keeper.syncNodeImage()
-	return nil
+	return cid, nil
 }
 
 func putImageInfo(infoKey string, imageInfo brisk.ImageInfo) string {
@@ -400,27 +491,28 @@ func stopImage(containerID string) error {
 }
 
 // runImage运行
-func runImage(imageFullName string, env map[string]string) (string, error) {
-	var runCmd *exec.Cmd
-	if len(env) != 0 {
-		envStr := ""
-		for key, value := range env {
-			envStr += fmt.Sprintf("-e %s%s=%s%s ", `"`, key, value, `"`)
-		}
-		portStr := "-p "
-		if env["Port"] == "" || env["ContainerPort"] == "" {
-			err := errors.New("docker run image : missing important parameters")
-			log.Printf("Error : %v，Port,ContainerPort \n", err)
-			return "", err
-		}
-		portStr += fmt.Sprintf("%s:%s ", env["Port"], env["ContainerPort"])
-		runCmd = exec.Command("sh", "-c", fmt.Sprintf("docker run -d %s%s%s", envStr, portStr, imageFullName))
-		log.Println(fmt.Sprintf("docker run -d %s%s%s", envStr, portStr, imageFullName))
-	} else {
-		runCmd = exec.Command("sh", "-c", fmt.Sprintf("docker run -d %s", imageFullName))
+// runImage 启动容器，env 中的 Port:ContainerPort 为服务端口映射，spec 为其余的容器参数，secrets 为引用的密钥
+// 参数直接传给 docker，不经过 shell，环境变量以及命令中的特殊字符不需要转义
+// 密钥在此时解密，值通过 docker 进程的环境变量传入（参数中只有 -e 环境变量名），日志中的密钥值替换为 ******
+func runImage(imageFullName string, env map[string]string, spec brisk.ContainerSpec, secrets map[string]string) (string, error) {
+	secretValues, err := resolveSecrets(secrets)
+	if err != nil {
+		log.Printf("Error : docker run %s fail, resolve secrets error: %v \n", imageFullName, err)
+		return "", err
+	}
+	args, err := dockerRunArgs(imageFullName, env, spec, secretValues)
+	if err != nil {
+		log.Printf("Error : %v，Port,ContainerPort \n", err)
+		return "", err
+	}
+	log.Println(redactSecrets("docker "+strings.Join(args, " "), secretValues))
+	runCmd := exec.Command("docker", args...)
+	runCmd.Env = os.Environ()
+	for key, value := range secretValues {
+		runCmd.Env = append(runCmd.Env, key+"="+value)
 	}
 	runStdout, err := runCmd.CombinedOutput()
-	stdoutStr := string(runStdout)
+	stdoutStr := redactSecrets(string(runStdout), secretValues)
 	// 去除换行符 避免意外情况
 	stdoutStr = strings.Replace(stdoutStr, "\n", "", -1)
 	// 去除空格 避免意外情况
@@ -433,6 +525,78 @@ func runImage(imageFullName string, env map[string]string) (string, error) {
 	return stdoutStr, nil
 }
 
+// dockerRunArgs docker run 的参数，密钥只传环境变量名，docker 从自身的环境变量中取值
+func dockerRunArgs(imageFullName string, env map[string]string, spec brisk.ContainerSpec, secretValues map[string]string) ([]string, error) {
+	args := []string{"run", "-d"}
+	if len(env) != 0 {
+		if env["Port"] == "" || env["ContainerPort"] == "" {
+			return nil, errors.New("docker run image : missing important parameters")
+		}
+		var keys []string
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			args = append(args, "-e", key+"="+env[key])
+		}
+		args = append(args, "-p", env["Port"]+":"+env["ContainerPort"])
+	}
+	var secretKeys []string
+	for key := range secretValues {
+		secretKeys = append(secretKeys, key)
+	}
+	sort.Strings(secretKeys)
+	for _, key := range secretKeys {
+		args = append(args, "-e", key)
+	}
+	args = append(args, spec.RunArgs()...)
+	args = append(args, imageFullName)
+	return append(args, spec.Command...), nil
+}
+
+// resolveSecrets 从 etcd 读取并解密引用的密钥，返回 环境变量名 -> 密钥的值
+func resolveSecrets(secrets map[string]string) (map[string]string, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	if secretErr != nil {
+		return nil, secretErr
+	}
+	values := make(map[string]string)
+	for env, name := range secrets {
+		resp, err := cli.Get(context.Background(), brisk.SecretPrefix+name)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Kvs) == 0 {
+			return nil, fmt.Errorf("secret %s does not exist", name)
+		}
+		var secret brisk.Secret
+		if err := json.Unmarshal(resp.Kvs[0].Value, &secret); err != nil {
+			return nil, fmt.Errorf("secret %s format error, err: %v", name, err)
+		}
+		// 以引用的名称解密，其他密钥的密文无法通过校验
+		secret.Name = name
+		value, err := secretBox.Open(secret)
+		if err != nil {
+			return nil, err
+		}
+		values[env] = value
+	}
+	return values, nil
+}
+
+// redactSecrets 将文本中的密钥值替换为 ******，打印日志之前使用
+func redactSecrets(text string, secretValues map[string]string) string {
+	for _, value := range secretValues {
+		if value != "" {
+			text = strings.Replace(text, value, "******", -1)
+		}
+	}
+	return text
+}
+
 func getOldImageInfo(name string, node string) (string, string) {
 	log.Printf("getOldImageInfo() name %s, node: %s \n", name, node)
 	log.Printf("successNodeImages %v \n", keeper.successNodeImages)
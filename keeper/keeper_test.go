@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"brisk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerRunArgs(t *testing.T) {
+	const image = "eglass/hello:v1"
+
+	args, err := dockerRunArgs(image, nil, brisk.ContainerSpec{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run", "-d", image}, args)
+
+	// 环境变量按 key 排序，Port:ContainerPort 为服务端口映射
+	env := map[string]string{"Port": "30001", "ContainerPort": "8080", "ServiceName": "hello"}
+	args, err = dockerRunArgs(image, env, brisk.ContainerSpec{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run", "-d", "-e", "ContainerPort=8080", "-e", "Port=30001", "-e", "ServiceName=hello", "-p", "30001:8080", image}, args)
+
+	_, err = dockerRunArgs(image, map[string]string{"ServiceName": "hello"}, brisk.ContainerSpec{}, nil)
+	assert.Error(t, err)
+
+	// 密钥只传环境变量名，值通过 docker 进程的环境变量传入，不出现在命令行中
+	args, err = dockerRunArgs(image, nil, brisk.ContainerSpec{}, map[string]string{"DB_PASSWORD": "p@ss", "API_KEY": "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run", "-d", "-e", "API_KEY", "-e", "DB_PASSWORD", image}, args)
+
+	// 容器参数在镜像名之前，命令在镜像名之后，参数中的空格以及引号原样传给 docker
+	spec := brisk.ContainerSpec{
+		Memory:  "512m",
+		Ports:   []brisk.PortMapping{{HostPort: "9090", ContainerPort: "9090", Protocol: "udp"}},
+		Volumes: []brisk.VolumeMount{{Source: "/data", Target: "/var/lib/hello", ReadOnly: true}},
+		Labels:  map[string]string{"team": "a b", "app": "hello"},
+		Command: []string{"serve", "--name", `"hello world"`},
+	}
+	args, err = dockerRunArgs(image, nil, spec, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run", "-d",
+		"--memory", "512m", "-p", "9090:9090/udp", "-v", "/data:/var/lib/hello:ro",
+		"--label", "app=hello", "--label", "team=a b",
+		image, "serve", "--name", `"hello world"`}, args)
+}
+
+func TestRedactSecrets(t *testing.T) {
+	secretValues := map[string]string{"DB_PASSWORD": "p@ss", "EMPTY": ""}
+	assert.Equal(t, "docker: error", redactSecrets("docker: error", nil))
+	assert.Equal(t, "****** failed, retry ******", redactSecrets("p@ss failed, retry p@ss", secretValues))
+	// 空值不替换
+	assert.Equal(t, "docker: error", redactSecrets("docker: error", map[string]string{"EMPTY": ""}))
+}
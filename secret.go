@@ -0,0 +1,87 @@
+package brisk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SecretPrefix 加密保存的密钥 secret-"Name"，值为 Secret
+const SecretPrefix = "secret-"
+
+// secretNamePattern 密钥名称只能包含字母，数字，以及 . _ -
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Secret etcd 中保存的密钥，Value 为加密之后的值，明文只在 keeper 启动容器时解密
+type Secret struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"value"` // base64(nonce + AES-GCM 密文)
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// ValidSecretName 密钥名称是否有效
+func ValidSecretName(name string) bool {
+	return secretNamePattern.MatchString(name)
+}
+
+// SecretBox 使用 center 与 keeper 共用的密钥（AES-256-GCM）加密/解密 Secret
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox encodedKey 为 base64 编码的32字节密钥，例如 openssl rand -base64 32 生成
+func NewSecretBox(encodedKey string) (*SecretBox, error) {
+	if encodedKey == "" {
+		return nil, errors.New("secret key is not configured")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, fmt.Errorf("secret key is not base64 encoded, err: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal 加密密钥的值，密钥名称作为附加数据，密文不能用于其他名称的密钥
+func (b *SecretBox) Seal(name, plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open 解密密钥的值
+func (b *SecretBox) Open(secret Secret) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(secret.Value)
+	if err != nil {
+		return "", fmt.Errorf("secret %s format error, err: %v", secret.Name, err)
+	}
+	size := b.aead.NonceSize()
+	if len(sealed) < size {
+		return "", fmt.Errorf("secret %s format error", secret.Name)
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:size], sealed[size:], []byte(secret.Name))
+	if err != nil {
+		return "", fmt.Errorf("secret %s can not be decrypted, err: %v", secret.Name, err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,42 @@
+package brisk
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	sealed, err := box.Seal("db-password", "p@ss")
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "p@ss")
+
+	plaintext, err := box.Open(Secret{Name: "db-password", Value: sealed})
+	assert.NoError(t, err)
+	assert.Equal(t, "p@ss", plaintext)
+
+	// 密钥名称是附加数据，换了名称无法解密
+	_, err = box.Open(Secret{Name: "other", Value: sealed})
+	assert.Error(t, err)
+	_, err = box.Open(Secret{Name: "db-password", Value: "%%%"})
+	assert.Error(t, err)
+	_, err = box.Open(Secret{Name: "db-password", Value: base64.StdEncoding.EncodeToString([]byte("short"))})
+	assert.Error(t, err)
+}
+
+func TestNewSecretBox(t *testing.T) {
+	for key, wantErr := range map[string]bool{
+		base64.StdEncoding.EncodeToString(make([]byte, 32)): false,
+		"":          true,
+		"not a key": true,
+		base64.StdEncoding.EncodeToString(make([]byte, 16)): true,
+	} {
+		_, err := NewSecretBox(key)
+		assert.Equal(t, wantErr, err != nil, "key: %q, err: %v", key, err)
+	}
+}
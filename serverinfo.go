@@ -42,6 +42,7 @@ type ServerInfo struct {
 	Host          string `json:"host"`
 	Address       string `json:"address"`
 	ServiceName   string `json:"serviceName"`
+	ContainerID   string `json:"containerID"` // 容器ID（容器内的 hostname，前12位）
 }
 
 type Server interface {
@@ -80,6 +81,7 @@ func HandleServiceLifeCycle(server Server) {
 			Host:          Host,
 			Address:       address,
 			ServiceName:   ServiceName,
+			ContainerID:   HostName,
 		}
 		log.Printf("key : %s ; value : %v", key, serviceInfo)
 		value, err := json.Marshal(serviceInfo)
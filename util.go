@@ -2,32 +2,25 @@ package brisk
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/smtp"
 )
 
-// SendMail 25端口（阿里云服务器 禁用25端口） 邮件发送 to: 收件人, subject: 邮件主题, body: 邮件具体信息
-func SendMail(to []string, subject, body string) error {
-	// Set up authentication information.
-	auth := smtp.PlainAuth("", "tech@epeijing.cn", "Eglass2018", "smtp.exmail.qq.com")
-	// Connect to the server, authenticate, set the sender and recipient,
-	// and send the email all in one step.
-	var mails string
-	for _, mailOne := range to {
-		mailStr := "To: " + mailOne + "\r\n"
-		mails = mails + mailStr
+// SendMailTLS 使用配置的邮件服务器 TLS 发送邮件 to: 收件人, subject: 邮件主题, body: 邮件具体信息
+func (config SMTPConfig) SendMailTLS(to []string, subject, body string) error {
+	if config.Host == "" || config.Username == "" {
+		return errors.New("smtp host and username are not configured")
 	}
-	msg := []byte(mails +
-		fmt.Sprintf("Subject: %s\r\n", subject) +
-		"\r\n" +
-		fmt.Sprintf("%s\r\n", body))
-	//smtpdm.aliyun.com:25
-	return smtp.SendMail("smtp.exmail.qq.com:25", auth, "tech@epeijing.cn", to, msg)
-}
-
-// SendMailTLS 465端口 发送邮件 邮件发送 to: 收件人, subject: 邮件主题, body: 邮件具体信息
-func SendMailTLS(to []string, subject, body string) error {
-	auth := smtp.PlainAuth("", "tech@epeijing.cn", "Eglass2018", "smtp.exmail.qq.com")
+	from := config.From
+	if from == "" {
+		from = config.Username
+	}
+	port := config.Port
+	if port == 0 {
+		port = 465
+	}
+	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
 	var mails string
 	for _, mailOne := range to {
 		mailStr := "To: " + mailOne + "\r\n"
@@ -38,11 +31,11 @@ func SendMailTLS(to []string, subject, body string) error {
 		"\r\n" +
 		fmt.Sprintf("%s\r\n", body))
 
-	conn, err := tls.Dial("tcp", "smtp.exmail.qq.com:465", nil)
+	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", config.Host, port), nil)
 	if err != nil {
 		return err
 	}
-	c, err := smtp.NewClient(conn, "smtp.exmail.qq.com")
+	c, err := smtp.NewClient(conn, config.Host)
 	if err != nil {
 		return err
 	}
@@ -50,7 +43,7 @@ func SendMailTLS(to []string, subject, body string) error {
 	if err != nil {
 		return err
 	}
-	err = c.Mail("tech@epeijing.cn")
+	err = c.Mail(from)
 	if err != nil {
 		return err
 	}
@@ -1,27 +1,94 @@
 package brisk
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
 // ServConfigs 服务配置
 type ServConfigs struct {
-	ServiceName string `yaml: servicename`
-	Replica     int    `yaml: replica` //服务节点个数，目前最多2个
-	Meta        Meta   `yaml: meta`
+	ServiceName string   `yaml: servicename`
+	Replica     int      `yaml: replica` //服务节点个数，目前最多2个
+	Meta        Meta     `yaml: meta`
+	Strategy    Strategy `yaml:"strategy"` // 升级策略，不配置时逐个滚动升级
+	// Placement 副本调度的约束：节点标签选择，与其他服务的亲和/反亲和
+	Placement Placement `yaml:"placement"`
+	// Secrets 以密钥作为环境变量，key 为环境变量名，value 为 secret-"Name" 中的密钥名称；keeper 启动容器时解密
+	Secrets map[string]string `yaml:"secrets"`
+}
+
+// reservedEnv center 为每个副本设置的环境变量，以及 keeper 调用 docker 时使用的环境变量，不能被密钥覆盖
+var reservedEnv = []string{"IP", "Port", "ContainerPort", "Host", "Etcd", "ServiceName", "PATH", "HOME"}
+
+// Placement 副本调度的约束，required 必须满足，preferred 尽量满足
+type Placement struct {
+	// NodeSelector 节点必须具有的标签，全部匹配
+	NodeSelector map[string]string `yaml:"nodeselector"`
+	// PreferredNodes 偏好的节点标签，节点每满足一条加 Weight 分
+	PreferredNodes []WeightedLabels `yaml:"preferrednodes"`
+	// Affinity 与这些服务的副本运行在同一节点
+	Affinity ServiceAffinity `yaml:"affinity"`
+	// AntiAffinity 不与这些服务的副本运行在同一节点，required 对双方都生效
+	AntiAffinity ServiceAffinity `yaml:"antiaffinity"`
+}
+
+// WeightedLabels 带权重的节点标签，标签全部匹配时加 Weight 分，Weight 默认为1
+type WeightedLabels struct {
+	Labels map[string]string `yaml:"labels"`
+	Weight int               `yaml:"weight"`
+}
+
+// ServiceAffinity 与其他服务的亲和/反亲和，值为服务名
+type ServiceAffinity struct {
+	Required  []string `yaml:"required"`
+	Preferred []string `yaml:"preferred"`
+}
+
+// 升级策略类型
+const (
+	// StrategyRolling 逐个滚动升级（默认）
+	StrategyRolling = "rolling"
+	// StrategyCanary 先升级一个金丝雀副本，观察一段时间健康后再升级其余副本
+	StrategyCanary = "canary"
+)
+
+// Strategy 服务升级策略
+type Strategy struct {
+	Type       string `yaml:"type"`       // rolling / canary
+	SoakTime   int    `yaml:"soaktime"`   // 金丝雀副本观察时间（秒）
+	HealthPath string `yaml:"healthpath"` // 金丝雀副本 HTTP 健康检查路径，例如 /health，为空时只检查服务注册
+	// InitialDelay 金丝雀副本启动后开始检查之前的等待时间（秒），不计入 SoakTime
+	InitialDelay int `yaml:"initialdelay"`
+	// FailureThreshold 连续检查失败多少次判定金丝雀副本失败，默认为1
+	FailureThreshold int `yaml:"failurethreshold"`
+	// MaxUnavailable 每批同时升级的副本数量，默认为1（逐个升级）
+	MaxUnavailable int `yaml:"maxunavailable"`
+	// Coalesce 升级期间推送的多个版本只保留 CreateTime 最新的一个，其余丢弃
+	Coalesce bool `yaml:"coalesce"`
 }
 
 type Meta struct {
+	// Port 宿主机端口，为 auto 时由 center 从节点的 PortRange 中为每个副本分配，同一节点上可以运行多个副本
 	Port          string `yaml: port`
 	ContainerPort string `yaml: containerport`
 	NeedNetPublic bool   `yaml: needNetpublic`
 	ImagePrefix   string `yaml: imageprefix`
 	Etcd          string `yaml: etcd`
+	// Container 资源限制，额外的端口映射，挂载，网络，重启策略，标签，命令，日志等容器参数
+	Container ContainerSpec `yaml:"container"`
 }
 
+// PortAuto Meta.Port 为 auto 时动态分配宿主机端口
+const PortAuto = "auto"
+
+// DefaultPortRange 节点没有配置 PortRange 时动态分配端口的范围
+const DefaultPortRange = "30000-32767"
+
 // AllServConfigs 所有的服务配置，key 为服务名
 type AllServConfigs map[string]ServConfigs
 
@@ -32,6 +99,28 @@ type NodeConfig struct {
 	PrivateIP     string `yaml: privateip`     // 私有IP
 	PublicIP      string `yaml: publicip`      // 公有IP
 	MaxContainers int    `yaml: maxcontainers` //节点最大容器数量
+	// Labels 节点标签，例如 disk: ssd，服务通过 Placement 选择节点
+	Labels map[string]string `yaml:"labels"`
+	// PortRange 动态分配宿主机端口的范围，例如 30000-32767，为空时使用 DefaultPortRange
+	PortRange string `yaml:"portrange"`
+}
+
+// Ports 动态分配宿主机端口的范围，包含两端
+func (n NodeConfig) Ports() (int, int, error) {
+	portRange := n.PortRange
+	if portRange == "" {
+		portRange = DefaultPortRange
+	}
+	bounds := strings.SplitN(portRange, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("portrange %q format error, expect low-high", portRange)
+	}
+	low, lowErr := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	high, highErr := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if lowErr != nil || highErr != nil || low <= 0 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("portrange %q is invalid", portRange)
+	}
+	return low, high, nil
 }
 
 // NodeConfigs 所有Node配置，key 节点名称
@@ -39,21 +128,168 @@ type NodeConfigs map[string]NodeConfig
 
 // ReadYamlFile 从yaml文件中获取数据 （通用）
 func ReadYamlFile(filePath string, out interface{}) {
+	err := LoadYamlFile(filePath, out)
+	if err != nil {
+		log.Fatalf("Error : %v \n", err)
+	}
+	log.Printf("Successful: yamlFile data Unmarshal successful, Data :%v", out)
+}
+
+// LoadYamlFile 从yaml文件中获取数据，出错时返回错误，不退出程序
+func LoadYamlFile(filePath string, out interface{}) error {
 	// 传入地址
 	yamlFile, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		log.Fatalf("Error : read yaml file error, Err: %v \n", err)
+		return fmt.Errorf("read yaml file error, file: %s, Err: %v", filePath, err)
 	}
-	log.Println("Successful : read yaml file successful")
 	err = yaml.Unmarshal(yamlFile, out)
 	if err != nil {
-		log.Fatalf("Error :yamlFile data Unmarshal error, Err: %v \n", err)
+		return fmt.Errorf("yamlFile data Unmarshal error, file: %s, Err: %v", filePath, err)
 	}
-	log.Printf("Successful: yamlFile data Unmarshal successful, Data :%v", out)
+	return nil
+}
+
+// Validate 检查服务配置是否完整有效
+func (c AllServConfigs) Validate() error {
+	for name, servConfig := range c {
+		if servConfig.ServiceName != name {
+			return fmt.Errorf("service %s: servicename %q does not match the key", name, servConfig.ServiceName)
+		}
+		// / 用于分隔 etcd key 中的服务名与版本号，# 用于分隔副本 key 中的服务名与序号
+		if name == "" || strings.ContainsAny(name, "/# ") {
+			return fmt.Errorf("service %q: servicename must not be empty or contain '/', '#' or spaces", name)
+		}
+		if servConfig.Replica <= 0 {
+			return fmt.Errorf("service %s: replica must be greater than 0", name)
+		}
+		if servConfig.Meta.ImagePrefix == "" {
+			return fmt.Errorf("service %s: imageprefix is empty", name)
+		}
+		if servConfig.Meta.Port == "" || servConfig.Meta.ContainerPort == "" {
+			return fmt.Errorf("service %s: port and containerport are required", name)
+		}
+		// 同一节点上的多个副本无法共用固定的额外端口映射
+		if servConfig.Meta.Port == PortAuto && len(servConfig.Meta.Container.Ports) > 0 {
+			return fmt.Errorf("service %s: container ports are not allowed when port is auto", name)
+		}
+		switch servConfig.Strategy.Type {
+		case "", StrategyRolling, StrategyCanary:
+		default:
+			return fmt.Errorf("service %s: unknown strategy type %q", name, servConfig.Strategy.Type)
+		}
+		if servConfig.Strategy.MaxUnavailable < 0 || servConfig.Strategy.SoakTime < 0 ||
+			servConfig.Strategy.InitialDelay < 0 || servConfig.Strategy.FailureThreshold < 0 {
+			return fmt.Errorf("service %s: strategy values must not be negative", name)
+		}
+		if err := servConfig.Meta.Container.Validate(); err != nil {
+			return fmt.Errorf("service %s: %v", name, err)
+		}
+		if err := servConfig.Placement.validate(name); err != nil {
+			return fmt.Errorf("service %s: %v", name, err)
+		}
+		for env, secret := range servConfig.Secrets {
+			if env == "" || strings.ContainsAny(env, "= ") {
+				return fmt.Errorf("service %s: secret env %q is invalid", name, env)
+			}
+			for _, reserved := range reservedEnv {
+				if env == reserved || strings.HasPrefix(env, "DOCKER_") {
+					return fmt.Errorf("service %s: secret env %s is reserved", name, env)
+				}
+			}
+			if !ValidSecretName(secret) {
+				return fmt.Errorf("service %s: secret name %q is invalid", name, secret)
+			}
+		}
+	}
+	return nil
+}
+
+// validate 检查调度约束：权重不能为负数，同一服务不能既亲和又反亲和，服务不能亲和自己
+func (p Placement) validate(serviceName string) error {
+	for _, preferred := range p.PreferredNodes {
+		if preferred.Weight < 0 {
+			return fmt.Errorf("placement: weight must not be negative")
+		}
+	}
+	affinity := append(append([]string{}, p.Affinity.Required...), p.Affinity.Preferred...)
+	antiAffinity := append(append([]string{}, p.AntiAffinity.Required...), p.AntiAffinity.Preferred...)
+	for _, name := range affinity {
+		if name == serviceName {
+			return fmt.Errorf("placement: affinity to itself")
+		}
+		for _, anti := range antiAffinity {
+			if anti == name {
+				return fmt.Errorf("placement: service %s is in both affinity and antiaffinity", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate 检查节点配置是否完整有效
+func (n NodeConfigs) Validate() error {
+	for name, node := range n {
+		if node.HostName != name {
+			return fmt.Errorf("node %s: hostname %q does not match the key", name, node.HostName)
+		}
+		if node.PrivateIP == "" {
+			return fmt.Errorf("node %s: privateip is empty", name)
+		}
+		if node.HasPublic && node.PublicIP == "" {
+			return fmt.Errorf("node %s: haspublic is true but publicip is empty", name)
+		}
+		if node.MaxContainers < 0 {
+			return fmt.Errorf("node %s: maxcontainers must not be negative", name)
+		}
+		if _, _, err := node.Ports(); err != nil {
+			return fmt.Errorf("node %s: %v", name, err)
+		}
+	}
+	return nil
 }
 
 // MailAddressees 邮件收信人  key: 收件人姓名， value: 收件人地址
 type MailAddressees map[string]string
 
-// MailMessage 邮件信息  key: 信息针对服务 value: 邮局具体信息
-type MailMessage map[string]string
+// 通知方式类型
+const (
+	NotifierSMTP     = "smtp"     // 邮件
+	NotifierWebhook  = "webhook"  // 通用 JSON webhook，请求体为通知的完整结构
+	NotifierSlack    = "slack"    // Slack incoming webhook
+	NotifierDingTalk = "dingtalk" // 钉钉机器人
+	NotifierWeCom    = "wecom"    // 企业微信机器人
+)
+
+// NotifyConfigs center 通知配置
+type NotifyConfigs struct {
+	SMTP      SMTPConfig       `yaml:"smtp"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+	// Routes 按服务以及级别选择通知方式，为空时所有通知发送给所有通知方式
+	Routes []NotifyRoute `yaml:"routes"`
+	// RepeatInterval 周期性检查发现的问题持续存在时重复通知的间隔(秒)，默认3600
+	RepeatInterval int `yaml:"repeat_interval"`
+}
+
+// SMTPConfig 邮件服务器配置，使用 TLS 连接
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"` // 默认465
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"` // 发件人，默认为 Username
+}
+
+// NotifierConfig 一个通知方式
+type NotifierConfig struct {
+	Name string   `yaml:"name"`
+	Type string   `yaml:"type"` // smtp / webhook / slack / dingtalk / wecom
+	URL  string   `yaml:"url"`  // webhook 以及机器人的地址
+	To   []string `yaml:"to"`   // 邮件收件人，为空时使用 MailAddressee.yaml 中的收件人
+}
+
+// NotifyRoute 服务为 Services 之一（为空时所有服务），并且级别不低于 Severity 的事件，发送给 Notifiers
+type NotifyRoute struct {
+	Services  []string `yaml:"services"`
+	Severity  string   `yaml:"severity"` // info / warning / error，为空时为 info
+	Notifiers []string `yaml:"notifiers"`
+}